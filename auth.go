@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// authFailure matches the reports of the go tool and git when a module
+// proxy or VCS host rejects a request for lack of credentials.
+var authFailure = regexp.MustCompile(`\b(401 Unauthorized|403 Forbidden)\b|reading \S+: (401|403)\b|terminal prompts disabled|could not read (Username|Password)`)
+
+// authAdvice is the advice given when a subprocess fails authentication.
+const authAdvice = "hint: a module proxy or VCS host rejected a request as unauthorized; " +
+	"check that GOPROXY names the intended proxy, that its credentials are in $NETRC or ~/.netrc, " +
+	"and that private modules are listed in GOPRIVATE or GONOSUMDB so that the public checksum database is not consulted"
+
+// authHint returns err with advice on configuring access to an
+// authenticated module proxy appended if err reports an authentication
+// failure. Otherwise err is returned unchanged.
+func authHint(err error) error {
+	if err == nil || !authFailure.MatchString(err.Error()) {
+		return err
+	}
+	return fmt.Errorf("%w\n%s", err, authAdvice)
+}
+
+// loadPackages loads the packages matching patterns with cfg. If loading
+// fails, or any package or dependency has errors, the package errors are
+// printed to stderr and a *loadError is returned.
+func loadPackages(cfg *packages.Config, patterns ...string) ([]*packages.Package, error) {
+	var pkgs []*packages.Package
+	err := span("packages.Load", func() error {
+		var err error
+		pkgs, err = packages.Load(cfg, patterns...)
+		return err
+	})
+	if err != nil {
+		return nil, &loadError{patterns: patterns, err: authHint(err)}
+	}
+	if n, failed := printErrors(pkgs); n != 0 {
+		return nil, &loadError{patterns: patterns, packages: failed, errors: n}
+	}
+	return pkgs, nil
+}
+
+// printErrors prints the errors in pkgs and their dependencies to stderr,
+// followed by advice on proxy authentication if any error reports an
+// authentication failure, and returns the number of errors and the IDs of
+// the packages that have them.
+func printErrors(pkgs []*packages.Package) (n int, failed []string) {
+	n = packages.PrintErrors(pkgs)
+	if n == 0 {
+		return 0, nil
+	}
+	var msgs []string
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		if len(pkg.Errors) != 0 {
+			failed = append(failed, pkg.ID)
+		}
+		for _, err := range pkg.Errors {
+			msgs = append(msgs, err.Msg)
+		}
+	})
+	if authFailure.MatchString(strings.Join(msgs, "\n")) {
+		fmt.Fprintln(os.Stderr, authAdvice)
+	}
+	return n, failed
+}
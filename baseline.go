@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// fetchReport fetches a capslock JSON report from the HTTP or HTTPS URL, u.
+// If the CL_BASELINE_AUTHORIZATION environment variable is set, its value
+// is sent as the Authorization header of the request.
+func fetchReport(u string) (*capslockReport, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("baseline-url: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("baseline-url: unsupported scheme: %q", parsed.Scheme)
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("baseline-url: %w", err)
+	}
+	if auth := os.Getenv("CL_BASELINE_AUTHORIZATION"); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	cli := http.Client{Timeout: time.Minute}
+	var b []byte
+	err = span("GET "+parsed.Redacted(), func() error {
+		resp, err := cli.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("GET %s: %s", parsed.Redacted(), resp.Status)
+		}
+		b, err = io.ReadAll(resp.Body)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("baseline-url: %w", err)
+	}
+	return parseReport(b)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sort"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// moduleBatches splits pkgs into batches of the packages of each module,
+// each of at most n packages if n is positive. The mods parameter maps
+// package import paths to their module; packages without a module are
+// batched together.
+func moduleBatches(pkgs []string, mods map[string]*packages.Module, n int) [][]string {
+	if len(pkgs) == 0 {
+		return [][]string{pkgs}
+	}
+	byMod := make(map[string][]string)
+	for _, p := range pkgs {
+		var path string
+		if m := mods[p]; m != nil {
+			path = m.Path
+		}
+		byMod[path] = append(byMod[path], p)
+	}
+	paths := make([]string, 0, len(byMod))
+	for path := range byMod {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var b [][]string
+	for _, path := range paths {
+		b = append(b, batches(byMod[path], n)...)
+	}
+	return b
+}
+
+// batchRunner analyses batches of packages with a bounded number of
+// concurrent capslock invocations. The results are collected in batch
+// order with wait.
+type batchRunner struct {
+	results []chan batchResult
+	done    chan struct{}
+}
+
+type batchResult struct {
+	report *capslockReport
+	err    error
+}
+
+// startBatches starts the analysis of bs with at most jobs concurrent
+// capslock invocations. The stop method of the returned runner must be
+// called when no more results are needed.
+func startBatches(cfg config, bs [][]string, jobs int) *batchRunner {
+	if jobs < 1 {
+		jobs = 1
+	}
+	r := &batchRunner{
+		results: make([]chan batchResult, len(bs)),
+		done:    make(chan struct{}),
+	}
+	for i := range r.results {
+		r.results[i] = make(chan batchResult, 1)
+	}
+	sem := make(chan struct{}, jobs)
+	go func() {
+		for i, b := range bs {
+			select {
+			case sem <- struct{}{}:
+			case <-r.done:
+				return
+			}
+			go func(i int, b []string) {
+				defer func() { <-sem }()
+				report, err := analyseBatch(cfg, b)
+				r.results[i] <- batchResult{report: report, err: err}
+			}(i, b)
+		}
+	}()
+	return r
+}
+
+// wait returns the report of the ith batch once its analysis is complete.
+func (r *batchRunner) wait(i int) (*capslockReport, error) {
+	res := <-r.results[i]
+	return res.report, res.err
+}
+
+// stop prevents the analysis of batches that have not been started.
+// Analyses in progress run to completion and their results are discarded.
+func (r *batchRunner) stop() {
+	close(r.done)
+}
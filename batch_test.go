@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Simulated capslock costs. Each invocation analyses the dependencies
+// shared by its packages, such as the standard library, before the
+// packages themselves.
+const (
+	sharedAnalysis  = 5 * time.Millisecond
+	packageAnalysis = 100 * time.Microsecond
+)
+
+// BenchmarkModuleBatches compares the analysis of the packages of many
+// modules in a single capslock invocation with their analysis in module
+// batches, which repeat the analysis of shared dependencies.
+func BenchmarkModuleBatches(b *testing.B) {
+	var imports []string
+	mods := make(map[string]*packages.Module)
+	for i := 0; i < 40; i++ {
+		mod := &packages.Module{Path: fmt.Sprintf("example.com/mod%d", i), Version: "v1.0.0"}
+		for j := 0; j < 5; j++ {
+			p := fmt.Sprintf("%s/pkg%d", mod.Path, j)
+			imports = append(imports, p)
+			mods[p] = mod
+		}
+	}
+	var calls atomic.Int64
+	orig := runCapslock
+	runCapslock = func(_ string, args, _ []string) (*bytes.Buffer, *bytes.Buffer, error) {
+		calls.Add(1)
+		time.Sleep(sharedAnalysis + time.Duration(len(packagesArg(args)))*packageAnalysis)
+		stdout, stderr, err := fakeAnalysis(args)
+		return bytes.NewBufferString(stdout), bytes.NewBufferString(stderr), err
+	}
+	b.Cleanup(func() { runCapslock = orig })
+
+	for _, test := range []struct {
+		name string
+		cfg  config
+	}{
+		{name: "single", cfg: config{jobs: 1}},
+		{name: "by-module", cfg: config{jobs: 1, batchByModule: true}},
+		{name: "by-module-jobs-4", cfg: config{jobs: 4, batchByModule: true}},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			calls.Store(0)
+			for i := 0; i < b.N; i++ {
+				bs := test.cfg.batches(imports, mods)
+				runner := startBatches(test.cfg, bs, test.cfg.jobs)
+				for j := range bs {
+					_, err := runner.wait(j)
+					if err != nil {
+						b.Fatalf("unexpected error: %v", err)
+					}
+				}
+				runner.stop()
+			}
+			b.ReportMetric(float64(calls.Load())/float64(b.N), "invocations/op")
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// binaries returns the first-party main packages in pkgs whose transitive
+// imports include each package, keyed by package import path.
+func binaries(pkgs []*packages.Package) map[string][]string {
+	reach := make(map[string][]string)
+	for _, pkg := range pkgs {
+		if pkg.Name != "main" || pkg.ID != pkg.PkgPath || strings.HasSuffix(pkg.ID, ".test") {
+			// Skip libraries, test variants and synthesized test
+			// main packages.
+			continue
+		}
+		seen := make(map[*packages.Package]bool)
+		var walk func(*packages.Package)
+		walk = func(p *packages.Package) {
+			for _, dep := range p.Imports {
+				if seen[dep] {
+					continue
+				}
+				seen[dep] = true
+				reach[dep.PkgPath] = append(reach[dep.PkgPath], pkg.PkgPath)
+				walk(dep)
+			}
+		}
+		walk(pkg)
+	}
+	for p, bins := range reach {
+		sort.Strings(bins)
+		reach[p] = bins
+	}
+	return reach
+}
+
+// attribute records in r the main packages in reach that import each
+// package with a capability.
+func attribute(r *capslockReport, reach map[string][]string) {
+	for i := range r.CapabilityInfo {
+		ci := &r.CapabilityInfo[i]
+		ci.Binaries = reach[ci.PackageDir]
+	}
+}
+
+// attributed returns whether any capability in r is attributed to a main
+// package, or r has no capabilities.
+func (r *capslockReport) attributed() bool {
+	for _, ci := range r.CapabilityInfo {
+		if len(ci.Binaries) != 0 {
+			return true
+		}
+	}
+	return len(r.CapabilityInfo) == 0
+}
+
+// byBinary returns the capabilities in r with each package replaced by
+// each of the main packages it is attributed to, so that capabilities are
+// compared at the granularity of binaries. The call path of each
+// capability starts in the dependency that brings it into the binary.
+// Capabilities of packages that are not imported
+// by any main package are omitted.
+func (r *capslockReport) byBinary() *capslockReport {
+	b := capslockReport{ModuleInfo: r.ModuleInfo}
+	for _, ci := range r.CapabilityInfo {
+		for _, bin := range ci.Binaries {
+			ci.PackageDir = bin
+			b.CapabilityInfo = append(b.CapabilityInfo, ci)
+		}
+	}
+	return &b
+}
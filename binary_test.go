@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+func TestBinaries(t *testing.T) {
+	dep := &packages.Package{ID: "example.com/dep", PkgPath: "example.com/dep", Name: "dep"}
+	testDep := &packages.Package{ID: "example.com/testdep", PkgPath: "example.com/testdep", Name: "testdep"}
+	cmd := &packages.Package{ID: "example.com/app/cmd", PkgPath: "example.com/app/cmd", Name: "main",
+		Imports: map[string]*packages.Package{"example.com/dep": dep},
+	}
+	// The test variant of the main package and the test main package
+	// synthesized by go list with -test.
+	cmdTest := &packages.Package{ID: "example.com/app/cmd [example.com/app/cmd.test]", PkgPath: "example.com/app/cmd", Name: "main",
+		Imports: map[string]*packages.Package{"example.com/dep": dep, "example.com/testdep": testDep},
+	}
+	testMain := &packages.Package{ID: "example.com/app/cmd.test", PkgPath: "example.com/app/cmd.test", Name: "main",
+		Imports: map[string]*packages.Package{"example.com/app/cmd": cmdTest},
+	}
+	got := binaries([]*packages.Package{cmd, cmdTest, testMain})
+	want := map[string][]string{"example.com/dep": {"example.com/app/cmd"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected binaries: got:%v want:%v", got, want)
+	}
+}
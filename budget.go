@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// budget is the set of capabilities that the packages matching a package
+// pattern are allowed to have.
+type budget struct {
+	pattern string
+	allowed map[string]bool
+}
+
+// readBudgets reads the capability budgets in the file at path. Each line
+// holds a package pattern followed by the comma or space separated names of
+// the capabilities allowed for the matching packages, for example
+//
+//	./cmd/server NETWORK,FILES
+//
+// The CAPABILITY_ prefix of capability names is optional. Blank lines and
+// comments are handled as in ignore files.
+func readBudgets(path string) ([]budget, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var budgets []budget
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _ := parseIgnoreLine(sc.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(strings.ReplaceAll(line, ",", " "))
+		b := budget{pattern: fields[0], allowed: make(map[string]bool)}
+		for _, c := range fields[1:] {
+			if !strings.HasPrefix(c, "CAPABILITY_") {
+				c = "CAPABILITY_" + c
+			}
+			b.allowed[c] = true
+		}
+		budgets = append(budgets, b)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(budgets) == 0 {
+		return nil, fmt.Errorf("%s: no budgets", path)
+	}
+	return budgets, nil
+}
+
+// overBudget returns the capabilities of the packages in r that are not
+// allowed by b, grouped by package.
+func overBudget(r *capslockReport, b budget) []change {
+	var over []change
+	caps := capabilities(r)
+	pkgs := make([]string, 0, len(caps))
+	for p := range caps {
+		pkgs = append(pkgs, p)
+	}
+	sort.Strings(pkgs)
+	for _, p := range pkgs {
+		c := change{Package: p, paths: make(map[string][]function)}
+		for capability, ci := range caps[p] {
+			if !b.allowed[capability] {
+				c.Added = append(c.Added, capability)
+				c.paths[capability] = ci.Path
+			}
+		}
+		if len(c.Added) != 0 {
+			sort.Strings(c.Added)
+			over = append(over, c)
+		}
+	}
+	return over
+}
+
+// writeBudgetViolations writes the capabilities of over that exceed the
+// budget with the given pattern to w.
+func writeBudgetViolations(w io.Writer, pattern string, over []change) {
+	for _, c := range over {
+		for _, capability := range c.Added {
+			fmt.Fprintf(w, "Package %s has capability %s which is not in the budget for %q.\n", c.Package, capability, pattern)
+			writeCallPath(w, c.paths[capability])
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// checkBudgets checks the packages matching each of the budgets in the file
+// at path against their allowed capabilities, writing any violations to w.
+// It returns whether all the budgets were met.
+func checkBudgets(w io.Writer, cfg config, path string) (ok bool, err error) {
+	budgets, err := readBudgets(path)
+	if err != nil {
+		return false, &inputError{source: "-budget", err: err}
+	}
+	ok = true
+	for _, b := range budgets {
+		pkgs, err := budgetPackages(cfg, path, b.pattern)
+		if err != nil {
+			return false, err
+		}
+		r, err := capslockJSON(cfg, pkgs)
+		if err != nil {
+			return false, err
+		}
+		over := overBudget(r, b)
+		if len(over) != 0 {
+			ok = false
+			writeBudgetViolations(w, b.pattern, over)
+		}
+	}
+	return ok, nil
+}
+
+// budgetPackages returns the import paths of the non-test packages
+// matching pattern from the budget file at path, loaded with the build
+// flags, tags and environment of the analysis so that capslock analyses
+// the packages that cl selected. It is an invocation error for the pattern
+// not to match any package.
+func budgetPackages(cfg config, path, pattern string) ([]string, error) {
+	loaded, err := loadPackages(cfg.loadConfig(), pattern)
+	var loadErr *loadError
+	if errors.As(err, &loadErr) && loadErr.err == nil {
+		// The package errors are the result of the pattern given.
+		return nil, &inputError{source: path, err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var pkgs []string
+	for _, pkg := range loaded {
+		if seen[pkg.PkgPath] || strings.HasSuffix(pkg.ID, ".test") || strings.HasSuffix(pkg.PkgPath, "_test") {
+			continue
+		}
+		seen[pkg.PkgPath] = true
+		pkgs = append(pkgs, pkg.PkgPath)
+	}
+	if len(pkgs) == 0 {
+		return nil, &inputError{source: path, err: fmt.Errorf("budget pattern %q matches no packages", pattern)}
+	}
+	sort.Strings(pkgs)
+	err = concrete(pkgs)
+	if err != nil {
+		return nil, err
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// severity is the default risk level of a capability.
+type severity int
+
+const (
+	low severity = iota
+	medium
+	high
+)
+
+func (s severity) String() string {
+	switch s {
+	case low:
+		return "low"
+	case medium:
+		return "medium"
+	case high:
+		return "high"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// capabilityTable is the set of capabilities reported by capslock with a
+// short description and default severity for each.
+var capabilityTable = []struct {
+	name        string
+	description string
+	severity    severity
+}{
+	{"CAPABILITY_FILES", "read or modify the file system", medium},
+	{"CAPABILITY_NETWORK", "interact with the network, connect to hosts and sockets, or listen for connections", high},
+	{"CAPABILITY_RUNTIME", "read or modify sensitive Go runtime state", medium},
+	{"CAPABILITY_READ_SYSTEM_STATE", "read the environment, network interfaces or process information", low},
+	{"CAPABILITY_MODIFY_SYSTEM_STATE", "modify the environment, working directory or signal handling", medium},
+	{"CAPABILITY_OPERATING_SYSTEM", "uncategorized operations in the os package", medium},
+	{"CAPABILITY_SYSTEM_CALLS", "make direct system calls", high},
+	{"CAPABILITY_ARBITRARY_EXECUTION", "invoke assembly or violate type safety", high},
+	{"CAPABILITY_CGO", "execute native code via cgo", high},
+	{"CAPABILITY_UNANALYZED", "call paths that capslock could not analyze", low},
+	{"CAPABILITY_UNSAFE_POINTER", "use unsafe.Pointer", high},
+	{"CAPABILITY_REFLECT", "use reflection to access values and call functions", medium},
+	{"CAPABILITY_EXEC", "execute other programs", high},
+}
+
+// capabilitySeverity returns the default severity of the named capability,
+// or low if it is not in the capability table.
+func capabilitySeverity(name string) severity {
+	for _, c := range capabilityTable {
+		if c.name == name {
+			return c.severity
+		}
+	}
+	return low
+}
+
+// listCapabilities writes the capability table to w.
+func listCapabilities(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CAPABILITY\tSEVERITY\tDESCRIPTION")
+	for _, c := range capabilityTable {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.name, c.severity, c.description)
+	}
+	return tw.Flush()
+}
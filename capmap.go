@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// flattenCapabilityMap returns the capslock capability map at path with
+// its includes resolved, and whether it had any includes. An include is a
+// line of the form "include FILE", with FILE relative to the directory of
+// the including map, and is replaced by the flattened contents of FILE, so
+// that includes are merged depth-first. When an entry for the same key is
+// given more than once, the last one is kept, so a map may override the
+// entries of the maps it includes. Include cycles are an error. Errors are
+// returned as an *inputError.
+func flattenCapabilityMap(path string) (flat []byte, included bool, err error) {
+	var (
+		lines []string
+		keys  = make(map[string]int) // Index of the kept line for each key.
+	)
+	var walk func(path string, stack []string) error
+	walk = func(path string, stack []string) error {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		for i, p := range stack {
+			if p == abs {
+				return fmt.Errorf("capability map include cycle: %s", strings.Join(append(stack[i:], abs), " -> "))
+			}
+		}
+		stack = append(stack, abs)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sc := bufio.NewScanner(bytes.NewReader(b))
+		for n := 1; sc.Scan(); n++ {
+			line := sc.Text()
+			content, _, _ := strings.Cut(line, "#")
+			args := strings.Fields(content)
+			if len(args) == 0 {
+				continue
+			}
+			if args[0] == "include" {
+				if len(args) != 2 {
+					return fmt.Errorf("%s:%d: invalid include format", path, n)
+				}
+				included = true
+				inc := args[1]
+				if !filepath.IsAbs(inc) {
+					inc = filepath.Join(filepath.Dir(path), inc)
+				}
+				err = walk(inc, stack)
+				if err != nil {
+					return fmt.Errorf("%s:%d: %w", path, n, err)
+				}
+				continue
+			}
+			key := mapKey(args)
+			if i, ok := keys[key]; ok {
+				lines[i] = ""
+			}
+			keys[key] = len(lines)
+			lines = append(lines, strings.TrimSpace(content))
+		}
+		return sc.Err()
+	}
+	err = walk(path, nil)
+	if err != nil {
+		return nil, false, &inputError{source: "-capability_map", err: err}
+	}
+	var buf bytes.Buffer
+	for _, l := range lines {
+		if l != "" {
+			buf.WriteString(l + "\n")
+		}
+	}
+	return buf.Bytes(), included, nil
+}
+
+// mapKey returns the key identifying the capability map entry with the
+// given fields, which capslock requires to be unique within a map.
+func mapKey(args []string) string {
+	n := 2
+	switch args[0] {
+	case "ignore_edge":
+		n = 3
+	case "cgo_suffix":
+		n = len(args)
+	}
+	if n > len(args) {
+		n = len(args)
+	}
+	return strings.Join(args[:n], " ")
+}
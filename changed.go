@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/execabs"
+	"golang.org/x/tools/go/packages"
+)
+
+// needsFullAnalysis returns whether any of the changed files may change
+// dependency resolution, so that the dependencies of all packages must be
+// analysed.
+func needsFullAnalysis(files []string) bool {
+	for _, f := range files {
+		switch filepath.Base(f) {
+		case "go.mod", "go.sum", "go.work", "go.work.sum":
+			return true
+		}
+	}
+	return false
+}
+
+// resolvedDir returns the directory dir with symlinks resolved, or dir
+// itself if it cannot be resolved, for example because it was deleted.
+func resolvedDir(dir string) string {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return dir
+	}
+	return resolved
+}
+
+// affectedPackages returns the packages in pkgs that may be affected by
+// the changed files. A package is affected if a changed Go file is in its
+// directory, so files that were deleted or are excluded by build
+// constraints are accounted for. Directories are compared with symlinks
+// resolved, so that files may be named through a symlink to the module.
+func affectedPackages(pkgs []*packages.Package, files []string) ([]*packages.Package, error) {
+	dirs := make(map[string]bool)
+	for _, f := range files {
+		if !strings.HasSuffix(f, ".go") {
+			continue
+		}
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return nil, err
+		}
+		dirs[resolvedDir(filepath.Dir(abs))] = true
+	}
+	var affected []*packages.Package
+	for _, pkg := range pkgs {
+		if len(pkg.GoFiles) != 0 && dirs[resolvedDir(filepath.Dir(pkg.GoFiles[0]))] {
+			affected = append(affected, pkg)
+		}
+	}
+	return affected, nil
+}
+
+// gitChangedFiles returns the paths of the files changed between the merge
+// base of the git revision ref and HEAD, and HEAD, in the git repository
+// containing the current directory.
+func gitChangedFiles(ref string) ([]string, error) {
+	out, err := git("diff", "--name-only", ref+"...HEAD")
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, f := range strings.Split(out, "\n") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return repoPaths(files)
+}
+
+// repoPaths returns files with relative paths resolved against the top
+// level of the git repository containing the current directory, as the
+// paths listed by git diff --name-only are. Absolute paths are unchanged.
+func repoPaths(files []string) ([]string, error) {
+	var top string
+	paths := make([]string, len(files))
+	for i, f := range files {
+		if filepath.IsAbs(f) {
+			paths[i] = f
+			continue
+		}
+		if top == "" {
+			var err error
+			top, err = git("rev-parse", "--show-toplevel")
+			if err != nil {
+				return nil, fmt.Errorf("resolving changed file %s: %w", f, err)
+			}
+		}
+		paths[i] = filepath.Join(top, filepath.FromSlash(f))
+	}
+	return paths, nil
+}
+
+// git returns the trimmed standard output of git run with args in the
+// current directory.
+func git(args ...string) (string, error) {
+	cmd := execabs.Command("git", args...)
+	var buf, errBuf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &errBuf
+	err := run(cmd)
+	if err != nil {
+		return "", fmt.Errorf("git %s %w: %s", args[0], err, strings.TrimSpace(errBuf.String()))
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
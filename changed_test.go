@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/execabs"
+)
+
+func TestRepoPaths(t *testing.T) {
+	if _, err := execabs.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	top, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cmd := execabs.Command("git", "init", "-q", top)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("unexpected error: %v: %s", err, out)
+	}
+	sub := filepath.Join(top, "sub")
+	err = os.Mkdir(sub, 0o755)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = os.Chdir(sub)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	abs := filepath.Join(t.TempDir(), "x.go")
+	got, err := repoPaths([]string{"sub/a.go", abs})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Relative paths are not relative to the current directory.
+	want := []string{filepath.Join(top, "sub", "a.go"), abs}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected paths: got:%q want:%q", got, want)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/execabs"
+)
+
+// changelog prints a markdown changelog of the capability changes between
+// the lock files in the module root at the git revisions from and to.
+func changelog(cfg config, from, to string) int {
+	root, valid, err := moduleRoot()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if valid {
+			return invocationError
+		}
+		return internalError
+	}
+	var reports [2]*capslockReport
+	for i, rev := range []string{from, to} {
+		b, err := gitShow(root, rev, "caps.lock")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return internalError
+		}
+		reports[i], err = parseReport(b)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:caps.lock: %v\n", rev, err)
+			return internalError
+		}
+	}
+	changes := diff(reports[0], reports[1], cfg.trackClassification)
+	err = writeChangelog(os.Stdout, from, to, changes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return internalError
+	}
+	return success
+}
+
+// gitShow returns the contents of the file at path, relative to dir, at
+// the git revision rev.
+func gitShow(dir, rev, path string) ([]byte, error) {
+	cmd := execabs.Command("git", "show", rev+":./"+path)
+	cmd.Dir = dir
+	var buf, errBuf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &errBuf
+	err := run(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("git show %w: %s", err, strings.TrimSpace(errBuf.String()))
+	}
+	return buf.Bytes(), nil
+}
+
+// writeChangelog writes changes between the revisions from and to to w as
+// a markdown section.
+func writeChangelog(w io.Writer, from, to string, changes []change) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "## Capability changes from %s to %s\n\n", from, to)
+	if len(changes) == 0 {
+		buf.WriteString("No capability changes.\n")
+	}
+	for _, c := range changes {
+		fmt.Fprintf(&buf, "### %s%s\n\n", c.Package, c.version())
+		writeChangelogList(&buf, "Added", c.Added, c.notes)
+		writeChangelogList(&buf, "Removed", c.Removed, c.notes)
+		writeChangelogList(&buf, "Now direct", c.Direct, c.notes)
+		buf.WriteByte('\n')
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeChangelogList(w io.Writer, label string, caps []string, notes map[string]string) {
+	for _, capability := range caps {
+		if note := notes[capability]; note != "" {
+			fmt.Fprintf(w, "- %s: `%s` (%s)\n", label, capability, note)
+		} else {
+			fmt.Fprintf(w, "- %s: `%s`\n", label, capability)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import "fmt"
+
+// classify prints whether each of the import paths is classified as a
+// standard library package for the GOOS, GOARCH and environment of cfg,
+// or the error from classifying it. It returns an invocation error if any
+// path could not be classified.
+func classify(cfg config, paths []string) int {
+	status := success
+	for _, p := range paths {
+		isStd, err := isStdlib(p, cfg.environ(), cfg.buildFlags()...)
+		switch {
+		case err != nil:
+			fmt.Printf("%s\terror: %v\n", p, err)
+			status = invocationError
+		case isStd:
+			fmt.Printf("%s\tstdlib\n", p)
+		default:
+			fmt.Printf("%s\tnot stdlib\n", p)
+		}
+	}
+	return status
+}
@@ -0,0 +1,76 @@
+// Package cltest provides a helper for checking in Go tests that the
+// capabilities of a module's dependencies have not drifted from a lock
+// generated by cl.
+//
+// The helper runs the cl executable, which must be installed along with
+// capslock, so that the test reports exactly what a cl check would.
+package cltest
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/execabs"
+)
+
+// cl exit status bits reporting capability drift.
+const (
+	capChangeError = 4
+	budgetError    = 8
+)
+
+// Config holds the options for a lock comparison.
+type Config struct {
+	// Command is the path of the cl executable. If it is empty, cl is
+	// found in $PATH.
+	Command string
+
+	// Dir is the directory cl is run in. If it is empty, the current
+	// directory, the directory of the package under test, is used.
+	Dir string
+
+	// Args are additional cl check flags, for example "-group-by=module".
+	Args []string
+
+	// Env is the environment cl is run with. If it is nil, the current
+	// process's environment is used.
+	Env []string
+}
+
+// CompareLock compares the capabilities of the module in cfg.Dir with the
+// lock at baselinePath, or the module's caps.lock if it is empty. A
+// relative baselinePath is relative to cfg.Dir. The test
+// fails with the reported changes if capabilities have changed or a budget
+// is exceeded, and fails immediately if cl cannot run the comparison.
+func CompareLock(t testing.TB, baselinePath string, cfg Config) {
+	t.Helper()
+	command := cfg.Command
+	if command == "" {
+		command = "cl"
+	}
+	args := append([]string{"check"}, cfg.Args...)
+	if baselinePath != "" {
+		args = append(args, baselinePath)
+	}
+	cmd := execabs.Command(command, args...)
+	cmd.Dir = cfg.Dir
+	cmd.Env = cfg.Env
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return
+	}
+	var exitErr *execabs.ExitError
+	if errors.As(err, &exitErr) {
+		status := exitErr.ExitCode()
+		if status&(capChangeError|budgetError) != 0 && status&^(capChangeError|budgetError) == 0 {
+			t.Errorf("capabilities have drifted from the lock:\n%s%s", &stdout, &stderr)
+			return
+		}
+	}
+	t.Fatalf("%s %s: %v\n%s", command, strings.Join(args, " "), err, &stderr)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// coverage summarises how much of the import set was analysed.
+type coverage struct {
+	total    int      // all imports considered
+	stdlib   int      // stdlib imports skipped
+	ignored  int      // imports matching ignore patterns
+	skipped  int      // imports skipped as too large
+	analysed int      // imports present in the capslock report
+	missing  []string // imports absent from the capslock report
+}
+
+// reportCoverage returns the coverage of the analysis of imports by the
+// analysed packages. The skipped stdlib, ignored and too large imports are
+// counted in the total. With stdlib analysis, stdlib imports are not listed
+// in capslock's package information, so the imports in std are counted as
+// analysed.
+func reportCoverage(analysed map[string]bool, imports []string, std map[string]bool, stdlib, ignored, skipped int) coverage {
+	c := coverage{total: len(imports) + stdlib + ignored + skipped, stdlib: stdlib, ignored: ignored, skipped: skipped}
+	for _, imp := range imports {
+		if !analysed[imp] && !std[imp] {
+			c.missing = append(c.missing, imp)
+			continue
+		}
+		c.analysed++
+	}
+	return c
+}
+
+// packages returns the packages with package information or capabilities
+// in r.
+func (r *capslockReport) packages() map[string]bool {
+	p := make(map[string]bool)
+	for _, pi := range r.PackageInfo {
+		p[pi.Path] = true
+	}
+	for _, ci := range r.CapabilityInfo {
+		p[ci.PackageDir] = true
+	}
+	return p
+}
+
+func (c coverage) String() string {
+	return fmt.Sprintf("analysed %d of %d imports (%d stdlib skipped, %d ignored, %d too large, %d errored)",
+		c.analysed, c.total, c.stdlib, c.ignored, c.skipped, len(c.missing))
+}
+
+// print writes the coverage summary to stderr if verbose is true, or as a
+// warning listing the missing imports if any imports were not analysed.
+func (c coverage) print(verbose bool) {
+	if len(c.missing) != 0 {
+		fmt.Fprintf(os.Stderr, "warning: %s: not analysed: %s\n", c, strings.Join(c.missing, ", "))
+		return
+	}
+	if verbose {
+		fmt.Fprintln(os.Stderr, c)
+	}
+}
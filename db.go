@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/execabs"
+)
+
+// dbSchema is the schema of the capability history database. Each run of
+// cl with -db adds a row to runs and a row to capabilities for each
+// package capability found by the run.
+const dbSchema = `CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY,
+	time TEXT NOT NULL,
+	revision TEXT,
+	command TEXT NOT NULL,
+	go_version TEXT
+);
+CREATE TABLE IF NOT EXISTS capabilities (
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	package TEXT NOT NULL,
+	module TEXT,
+	version TEXT,
+	capability TEXT NOT NULL,
+	type TEXT,
+	PRIMARY KEY (run_id, package, capability)
+);
+`
+
+// recordRun appends the package capabilities in r, found by the given
+// command at time now, to the SQLite database at path, creating it if
+// necessary. The git revision of the module at root, if any, and the Go
+// version in meta are recorded with the run. The database is written with
+// the sqlite3 command line tool in a single transaction.
+func recordRun(path, root, command string, now time.Time, meta metadata, r *capslockReport) error {
+	var sql strings.Builder
+	sql.WriteString(dbSchema)
+	sql.WriteString("BEGIN IMMEDIATE;\n")
+	fmt.Fprintf(&sql, "INSERT INTO runs (time, revision, command, go_version) VALUES (%s, %s, %s, %s);\n",
+		sqlString(now.UTC().Format(time.RFC3339)), sqlString(gitRevision(root)), sqlString(command), sqlString(meta.GoVersion))
+	versions := moduleVersions(r)
+	caps := capabilities(r)
+	pkgs := make([]string, 0, len(caps))
+	for pkg := range caps {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		names := make([]string, 0, len(caps[pkg]))
+		for capability := range caps[pkg] {
+			names = append(names, capability)
+		}
+		sort.Strings(names)
+		mod := moduleFor(pkg, versions)
+		for _, capability := range names {
+			fmt.Fprintf(&sql, "INSERT INTO capabilities VALUES ((SELECT max(id) FROM runs), %s, %s, %s, %s, %s);\n",
+				sqlString(pkg), sqlString(mod), sqlString(versions[mod]), sqlString(capability), sqlString(caps[pkg][capability].CapabilityType))
+		}
+	}
+	sql.WriteString("COMMIT;\n")
+
+	cmd := execabs.Command("sqlite3", "-bail", path)
+	cmd.Stdin = strings.NewReader(sql.String())
+	var errBuf bytes.Buffer
+	cmd.Stderr = &errBuf
+	err := run(cmd)
+	if err != nil && errBuf.Len() != 0 {
+		return fmt.Errorf("sqlite3 %s: %w: %s", path, err, strings.TrimSpace(errBuf.String()))
+	}
+	if err != nil {
+		return fmt.Errorf("sqlite3 %s: %w", path, err)
+	}
+	return nil
+}
+
+// sqlString returns s as an SQL string literal, or NULL if s is empty.
+func sqlString(s string) string {
+	if s == "" {
+		return "NULL"
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// gitRevision returns the commit hash of HEAD in the git repository
+// containing dir, or the empty string if it is not in a repository.
+func gitRevision(dir string) string {
+	cmd := execabs.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	if run(cmd) != nil {
+		return ""
+	}
+	return strings.TrimSpace(buf.String())
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"golang.org/x/tools/go/packages"
+)
+
+// change is the set of capability changes for a single package.
+type change struct {
+	Package string
+	Added   []string
+	Removed []string
+	Direct  []string // capabilities that were transitive in the baseline and are now direct
+
+	// current is the complete current capability set of the package
+	// when the context of changes is reported, otherwise nil.
+	current []string
+
+	// from and to are the baseline and current versions of the
+	// package's module if they differ.
+	from, to string
+	// module and moduleVersion are the current module providing the
+	// package and its version, if known.
+	module, moduleVersion string
+
+	// paths holds an example call path for each changed capability.
+	paths map[string][]function
+	// notes holds the baseline note for each changed capability
+	// that has one.
+	notes map[string]string
+}
+
+// diff returns the per-package capability changes from baseline to current,
+// sorted by package path. If classification is true, capabilities that were
+// only reached transitively in the baseline but are reached directly in
+// current are also reported.
+func diff(baseline, current *capslockReport, classification bool) []change {
+	base := capabilities(baseline)
+	curr := capabilities(current)
+	notes := baseline.notes()
+	baseVers := moduleVersions(baseline)
+	currVers := moduleVersions(current)
+	var pkgs []string
+	for p := range base {
+		pkgs = append(pkgs, p)
+	}
+	for p := range curr {
+		if _, ok := base[p]; !ok {
+			pkgs = append(pkgs, p)
+		}
+	}
+	sort.Strings(pkgs)
+	var changes []change
+	for _, p := range pkgs {
+		c := change{Package: p, paths: make(map[string][]function), notes: make(map[string]string)}
+		for capability, ci := range curr[p] {
+			was, ok := base[p][capability]
+			if !ok {
+				c.Added = append(c.Added, capability)
+				c.paths[capability] = ci.Path
+				continue
+			}
+			if classification && ci.CapabilityType == direct && was.CapabilityType == transitive {
+				c.Direct = append(c.Direct, capability)
+				c.paths[capability] = ci.Path
+				if note, ok := notes[capKey{p, capability}]; ok {
+					c.notes[capability] = note
+				}
+			}
+		}
+		for capability, ci := range base[p] {
+			if _, ok := curr[p][capability]; !ok {
+				c.Removed = append(c.Removed, capability)
+				c.paths[capability] = ci.Path
+				if note, ok := notes[capKey{p, capability}]; ok {
+					c.notes[capability] = note
+				}
+			}
+		}
+		if len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Direct) == 0 {
+			continue
+		}
+		sort.Strings(c.Added)
+		sort.Strings(c.Removed)
+		sort.Strings(c.Direct)
+		c.current = make([]string, 0, len(curr[p]))
+		for capability := range curr[p] {
+			c.current = append(c.current, capability)
+		}
+		sort.Strings(c.current)
+		c.module = moduleFor(p, currVers)
+		c.moduleVersion = currVers[c.module]
+		if mod := c.module; mod != "" && moduleFor(p, baseVers) == mod {
+			if from, to := baseVers[mod], currVers[mod]; from != to {
+				c.from, c.to = from, to
+			}
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// moduleVersions returns the versions of the modules in r keyed by module path.
+func moduleVersions(r *capslockReport) map[string]string {
+	v := make(map[string]string, len(r.ModuleInfo))
+	for _, mi := range r.ModuleInfo {
+		v[mi.Path] = mi.Version
+	}
+	return v
+}
+
+// moduleFor returns the path of the module in versions that provides the
+// package pkg, or the empty string if there is none.
+func moduleFor(pkg string, versions map[string]string) string {
+	var mod string
+	for path := range versions {
+		if (pkg == path || strings.HasPrefix(pkg, path+"/")) && len(path) > len(mod) {
+			mod = path
+		}
+	}
+	return mod
+}
+
+// Capability classifications.
+const (
+	direct     = "CAPABILITY_TYPE_DIRECT"
+	transitive = "CAPABILITY_TYPE_TRANSITIVE"
+)
+
+// capabilities returns the capabilities in r grouped by package and then by
+// capability. The first example of each package capability is retained,
+// unless a later example is direct and the retained example is not, so the
+// classification of the retained example is direct if any example is.
+func capabilities(r *capslockReport) map[string]map[string]capabilityInfo {
+	m := make(map[string]map[string]capabilityInfo)
+	for _, ci := range r.CapabilityInfo {
+		caps, ok := m[ci.PackageDir]
+		if !ok {
+			caps = make(map[string]capabilityInfo)
+			m[ci.PackageDir] = caps
+		}
+		if was, ok := caps[ci.Capability]; !ok || (ci.CapabilityType == direct && was.CapabilityType != direct) {
+			caps[ci.Capability] = ci
+		}
+	}
+	return m
+}
+
+// dropPrerelease returns changes with changes to packages provided by modules
+// at a prerelease or pseudo-version removed. The mods parameter maps package
+// import paths to their module. If verbose is true, dropped changes are
+// noted on stderr.
+func dropPrerelease(changes []change, mods map[string]*packages.Module, verbose bool) []change {
+	kept := changes[:0]
+	for _, c := range changes {
+		m := mods[c.Package]
+		if m != nil && m.Replace != nil {
+			m = m.Replace
+		}
+		if m != nil && isPrerelease(m.Version) {
+			if verbose {
+				fmt.Fprintf(os.Stderr, "ignoring changes in %s: %s is at %s\n", c.Package, m.Path, m.Version)
+			}
+			continue
+		}
+		kept = append(kept, c)
+	}
+	return kept
+}
+
+// isPrerelease returns whether the module version v is a prerelease or
+// pseudo-version.
+func isPrerelease(v string) bool {
+	return semver.Prerelease(v) != "" || module.IsPseudoVersion(v)
+}
+
+// changeFormats are the valid output formats for capability changes.
+var changeFormats = map[string]bool{"text": true, "compact": true, "junit": true, "osv-ish": true}
+
+// writeChanges writes changes to w in the given format.
+func writeChanges(w io.Writer, format string, changes []change) error {
+	switch format {
+	case "compact":
+		return writeCompact(w, changes)
+	case "junit":
+		return writeJUnit(w, nil, changes)
+	case "osv-ish":
+		return writeOSV(w, changes, time.Now())
+	default:
+		return writeText(w, changes)
+	}
+}
+
+// writeText writes changes to w in the style of capslock's compare output.
+func writeText(w io.Writer, changes []change) error {
+	var sep bool
+	entry := func(format string, c change, capability string) error {
+		if sep {
+			_, err := fmt.Fprintln(w)
+			if err != nil {
+				return err
+			}
+		}
+		sep = true
+		_, err := fmt.Fprintf(w, format, c.Package, capability, c.version())
+		if err != nil {
+			return err
+		}
+		err = writeNote(w, c.notes[capability])
+		if err != nil {
+			return err
+		}
+		return writeCallPath(w, c.paths[capability])
+	}
+	for _, c := range changes {
+		for _, capability := range c.Added {
+			err := entry("Package %s has new capability %s compared to the baseline%s.\n", c, capability)
+			if err != nil {
+				return err
+			}
+		}
+		for _, capability := range c.Removed {
+			err := entry("Package %s no longer has capability %s which was in the baseline%s.\n", c, capability)
+			if err != nil {
+				return err
+			}
+		}
+		for _, capability := range c.Direct {
+			err := entry("Package %s now has direct capability %s which was transitive in the baseline%s.\n", c, capability)
+			if err != nil {
+				return err
+			}
+		}
+		if c.current != nil {
+			_, err := fmt.Fprintf(w, "\nPackage %s now has capabilities: %s\n", c.Package, capabilityList(c.current))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// version returns a label for the change in the version of the package's
+// module, or the empty string if the version did not change.
+func (c change) version() string {
+	if c.from == "" && c.to == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (version %s → %s)", c.from, c.to)
+}
+
+// capabilityList returns the capabilities in caps as a comma-separated
+// list, or "none" if caps is empty.
+func capabilityList(caps []string) string {
+	if len(caps) == 0 {
+		return "none"
+	}
+	return strings.Join(caps, ", ")
+}
+
+func writeNote(w io.Writer, note string) error {
+	if note == "" {
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "Note: %s\n", note)
+	return err
+}
+
+func writeCallPath(w io.Writer, fns []function) error {
+	tw := tabwriter.NewWriter(w, 10, 8, 2, ' ', 0)
+	for _, f := range fns {
+		if f.Site != nil {
+			_, err := fmt.Fprintf(tw, "%s:%d:%d", f.Site.Filename, f.Site.Line, f.Site.Column)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintf(tw, "\t%s\n", f.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// writeCompact writes changes to w with one tab-separated line per changed
+// package in the form "PACKAGE\tADDED:cap1,cap2\tREMOVED:cap3". If any
+// capabilities became direct, a "DIRECT:cap4" field is appended, and if the
+// version of the package's module changed, a "VERSION:v1.0.0→v1.1.0" field
+// is appended. If the context of changes is reported, a "CURRENT:cap1,cap4"
+// field listing the package's complete current capability set is appended.
+func writeCompact(w io.Writer, changes []change) error {
+	for _, c := range changes {
+		var direct string
+		if len(c.Direct) != 0 {
+			direct = "\tDIRECT:" + strings.Join(c.Direct, ",")
+		}
+		var version string
+		if c.from != "" || c.to != "" {
+			version = "\tVERSION:" + c.from + "→" + c.to
+		}
+		var current string
+		if c.current != nil {
+			current = "\tCURRENT:" + strings.Join(c.current, ",")
+		}
+		_, err := fmt.Fprintf(w, "%s\tADDED:%s\tREMOVED:%s%s%s%s\n", c.Package, strings.Join(c.Added, ","), strings.Join(c.Removed, ","), direct, version, current)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// failWriter writes at most n bytes and then fails.
+type failWriter struct {
+	n int
+}
+
+var errWrite = errors.New("write failed")
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errWrite
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriteChangesErrors(t *testing.T) {
+	changes := []change{{
+		Package: "example.com/dep",
+		Added:   []string{"CAPABILITY_FILES"},
+		Removed: []string{"CAPABILITY_NETWORK"},
+		Direct:  []string{"CAPABILITY_EXEC"},
+		current: []string{"CAPABILITY_EXEC", "CAPABILITY_FILES"},
+		paths: map[string][]function{
+			"CAPABILITY_FILES": {
+				{Name: "example.com/dep.Open", Site: &site{Filename: "dep.go", Line: 10, Column: 2}},
+				{Name: "os.Open"},
+			},
+		},
+		notes: map[string]string{"CAPABILITY_EXEC": "runs the compiler"},
+	}}
+	for _, format := range []string{"text", "compact"} {
+		var buf bytes.Buffer
+		err := writeChanges(&buf, format, changes)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", format, err)
+		}
+		for n := 0; n < buf.Len(); n++ {
+			err := writeChanges(&failWriter{n: n}, format, changes)
+			if !errors.Is(err, errWrite) {
+				t.Errorf("unexpected error for %s failing after %d of %d bytes: %v", format, n, buf.Len(), err)
+			}
+		}
+	}
+}
+
+func TestWriteReportErrors(t *testing.T) {
+	owners := []capabilityOwners{{
+		Capability: "CAPABILITY_FILES",
+		Severity:   "medium",
+		Dependencies: []ownedDependency{
+			{Package: "example.com/dep", Module: "example.com/dep", Version: "v1.0.0", Direct: true, ReachedBy: []string{"example.com/app"}},
+			{Package: "example.com/dep/sub", ReachedBy: []string{"example.com/app"}},
+		},
+	}}
+	removals := []capKey{{"example.com/dep", "CAPABILITY_NETWORK"}}
+	for _, test := range []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{name: "owners", write: func(w io.Writer) error { return writeOwners(w, "markdown", owners) }},
+		{name: "no owners", write: func(w io.Writer) error { return writeOwners(w, "markdown", nil) }},
+		{name: "removals", write: func(w io.Writer) error { return writeRemovals(w, "caps.reviewed", removals, true) }},
+	} {
+		var buf bytes.Buffer
+		err := test.write(&buf)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.name, err)
+		}
+		for n := 0; n < buf.Len(); n++ {
+			err := test.write(&failWriter{n: n})
+			if !errors.Is(err, errWrite) {
+				t.Errorf("unexpected error for %s failing after %d of %d bytes: %v", test.name, n, buf.Len(), err)
+			}
+		}
+	}
+}
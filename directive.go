@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// allowDirective is the prefix of a source comment accepting capabilities
+// of an imported package, for example
+//
+//	import "example.com/client" //cl:allow network,files
+const allowDirective = "//cl:allow "
+
+// allowedImports returns change ignores for the capabilities accepted by
+// //cl:allow directives on the import specs of the Go files of pkgs. A
+// directive may be a line comment on the import spec or the comment
+// immediately before it, and lists capabilities separated by commas or
+// spaces, with the CAPABILITY_ prefix optional and in any case. Only the
+// import declarations of files containing a directive are parsed. An
+// unknown capability is an *inputError. The pkgs must have been loaded
+// with packages.NeedFiles.
+func allowedImports(pkgs []*packages.Package) ([]changeIgnore, error) {
+	var ignores []changeIgnore
+	fset := token.NewFileSet()
+	for _, pkg := range pkgs {
+		for _, name := range pkg.GoFiles {
+			src, err := os.ReadFile(name)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Contains(src, []byte(allowDirective)) {
+				continue
+			}
+			f, err := parser.ParseFile(fset, name, src, parser.ImportsOnly|parser.ParseComments)
+			if err != nil {
+				return nil, err
+			}
+			for _, imp := range f.Imports {
+				for _, g := range []*ast.CommentGroup{imp.Doc, imp.Comment} {
+					if g == nil {
+						continue
+					}
+					for _, c := range g.List {
+						caps, ok := strings.CutPrefix(c.Text, allowDirective)
+						if !ok {
+							continue
+						}
+						pos := fset.Position(c.Pos())
+						path, err := strconv.Unquote(imp.Path.Value)
+						if err != nil {
+							return nil, err
+						}
+						m := &matcher{
+							re:     regexp.MustCompile("^" + regexp.QuoteMeta(path) + "$"),
+							source: fmt.Sprintf("%s:%d", pos.Filename, pos.Line),
+						}
+						for _, capability := range strings.FieldsFunc(caps, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+							capability = strings.ToUpper(capability)
+							if !strings.HasPrefix(capability, "CAPABILITY_") {
+								capability = "CAPABILITY_" + capability
+							}
+							if !knownCapability(capability) {
+								return nil, &inputError{source: m.source, err: fmt.Errorf("unknown capability in %s directive: %q", strings.TrimSpace(allowDirective), capability)}
+							}
+							ignores = append(ignores, changeIgnore{pkg: m, capability: capability})
+						}
+					}
+				}
+			}
+		}
+	}
+	return ignores, nil
+}
+
+// knownCapability returns whether name is a capability reported by
+// capslock.
+func knownCapability(name string) bool {
+	for _, c := range capabilityTable {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+func TestAllowedImports(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"allow.go": `package p
+
+import (
+	"example.com/client" //cl:allow network,files
+
+	//cl:allow exec
+	"example.com/runner"
+)
+`,
+		// Files without directives are not parsed.
+		"broken.go": "package p\n\nimport (\n",
+		"unknown.go": `package p
+
+import "example.com/other" //cl:allow teleport
+`,
+	}
+	for name, src := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	pkg := func(names ...string) []*packages.Package {
+		p := &packages.Package{}
+		for _, n := range names {
+			p.GoFiles = append(p.GoFiles, filepath.Join(dir, n))
+		}
+		return []*packages.Package{p}
+	}
+
+	ignores, err := allowedImports(pkg("allow.go", "broken.go"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got [][2]string
+	for _, ig := range ignores {
+		got = append(got, [2]string{ig.pkg.re.String(), ig.capability})
+	}
+	want := [][2]string{
+		{`^example\.com/client$`, "CAPABILITY_NETWORK"},
+		{`^example\.com/client$`, "CAPABILITY_FILES"},
+		{`^example\.com/runner$`, "CAPABILITY_EXEC"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected ignores:\ngot: %q\nwant:%q", got, want)
+	}
+
+	_, err = allowedImports(pkg("unknown.go"))
+	if err == nil {
+		t.Fatal("expected error for unknown capability")
+	}
+	if got := errorStatus(err); got != invocationError {
+		t.Errorf("unexpected status for %v: got:%d want:%d", err, got, invocationError)
+	}
+}
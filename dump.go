@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// dumper records the raw output of capslock invocations when it is not nil.
+var dumper *capslockDumper
+
+// capslockDumper writes the arguments, exit status, standard output and
+// standard error of each capslock invocation, unmodified.
+type capslockDumper struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// startDump starts dumping capslock output to the file at path. The
+// returned function must be called to stop dumping and close the file.
+func startDump(path string) (stop func() error, err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	dumper = &capslockDumper{w: f}
+	return func() error {
+		dumper = nil
+		return f.Close()
+	}, nil
+}
+
+func (d *capslockDumper) record(args []string, stdout, stderr []byte, err error) {
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintf(d.w, "=== capslock %s\n=== status: %s\n=== stdout\n", strings.Join(args, " "), status)
+	writeDumpSection(d.w, stdout)
+	fmt.Fprintln(d.w, "=== stderr")
+	writeDumpSection(d.w, stderr)
+}
+
+// writeDumpSection writes b to w, ending it with a newline if it has none.
+func writeDumpSection(w io.Writer, b []byte) {
+	w.Write(b)
+	if len(b) != 0 && b[len(b)-1] != '\n' {
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Exit codes of the capslock executable.
+const (
+	capslockDifferent = 1 // the compared capabilities differ
+	capslockFailed    = 2 // analysis or invocation failed
+)
+
+// capslockError is returned when the capslock executable cannot be run or
+// exits with a non-zero status.
+type capslockError struct {
+	packages []string // packages being analysed
+	code     int      // exit code, -1 if capslock was terminated by a signal and 0 if it did not run
+	stderr   string   // standard error of capslock
+	err      error
+}
+
+func (e *capslockError) Error() string {
+	var msg string
+	switch e.code {
+	case capslockDifferent:
+		msg = "capslock reported capability differences"
+	case capslockFailed, 0:
+		msg = fmt.Sprintf("capslock: %v", e.err)
+	case -1:
+		msg = fmt.Sprintf("capslock was terminated: %v", e.err)
+	default:
+		msg = fmt.Sprintf("capslock: unexpected %v", e.err)
+	}
+	if e.stderr == "" {
+		return msg
+	}
+	return msg + ": " + e.stderr
+}
+
+func (e *capslockError) Unwrap() error { return e.err }
+
+// unsupportedFlagError is returned when the capslock executable does not
+// support a flag passed by cl, usually because it is older than the
+// feature that requires the flag.
+type unsupportedFlagError struct {
+	flag    string // flag name without leading dashes
+	feature string // cl feature that requires the flag
+	err     error
+}
+
+func (e *unsupportedFlagError) Error() string {
+	if e.feature == "" {
+		return fmt.Sprintf("capslock does not support the -%s flag used by cl: install a newer capslock version (%v)", e.flag, e.err)
+	}
+	return fmt.Sprintf("capslock does not support the -%s flag required by %s: install a newer capslock version (%v)", e.flag, e.feature, e.err)
+}
+
+func (e *unsupportedFlagError) Unwrap() error { return e.err }
+
+// loadError is returned when the packages matching patterns cannot be
+// loaded, or when some of them have errors. The package errors have been
+// printed to stderr when it is returned.
+type loadError struct {
+	patterns []string
+	packages []string // IDs of the packages with errors
+	errors   int      // number of package errors
+	err      error    // error from loading, nil if only packages have errors
+}
+
+func (e *loadError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("load: %v", e.err)
+	}
+	return fmt.Sprintf("load %s: %d package errors in %s", strings.Join(e.patterns, " "), e.errors, strings.Join(e.packages, ", "))
+}
+
+func (e *loadError) Unwrap() error { return e.err }
+
+// inputError is returned when a file or value given by the user, such as
+// an ignore file, a budget file or a capability map, is invalid.
+type inputError struct {
+	source string // path of the file or name of the flag
+	err    error
+}
+
+func (e *inputError) Error() string {
+	return fmt.Sprintf("%s: %v", e.source, e.err)
+}
+
+func (e *inputError) Unwrap() error { return e.err }
+
+// errorStatus returns the exit status for an analysis error. Invalid input
+// and an unsupported capslock flag are invocation errors, since they are
+// resolved by correcting the input, or by upgrading capslock or not using
+// the feature that requires the flag. Capability differences reported by
+// capslock, for example when it is asked to compare with -capslock-arg,
+// are a capability change. Other errors are internal errors.
+func errorStatus(err error) int {
+	var (
+		inErr   *inputError
+		flagErr *unsupportedFlagError
+		capsErr *capslockError
+	)
+	switch {
+	case errors.As(err, &inErr), errors.As(err, &flagErr):
+		return invocationError
+	case errors.As(err, &capsErr) && capsErr.code == capslockDifferent:
+		return capChangeError
+	}
+	return internalError
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	for _, test := range []struct {
+		mode     string
+		wantCode int // exit code recorded in the capslockError
+		want     int
+	}{
+		{mode: "different", wantCode: capslockDifferent, want: capChangeError},
+		{mode: "failed", wantCode: capslockFailed, want: internalError},
+		{mode: "signal", wantCode: -1, want: internalError},
+		{mode: "undefined", want: invocationError},
+	} {
+		t.Run(test.mode, func(t *testing.T) {
+			if test.mode == "signal" && runtime.GOOS == "windows" {
+				t.Skip("process termination is not reported as a signal on windows")
+			}
+			installFakeCapslock(t, test.mode)
+			cfg := config{goos: runtime.GOOS, goarch: runtime.GOARCH}
+			_, err := capslockJSON(cfg, []string{"example.com/dep"})
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if got := errorStatus(err); got != test.want {
+				t.Errorf("unexpected status for %v: got:%d want:%d", err, got, test.want)
+			}
+			var (
+				capsErr *capslockError
+				flagErr *unsupportedFlagError
+			)
+			switch {
+			case errors.As(err, &capsErr):
+				if capsErr.code != test.wantCode {
+					t.Errorf("unexpected exit code: got:%d want:%d", capsErr.code, test.wantCode)
+				}
+				if len(capsErr.packages) != 1 || capsErr.packages[0] != "example.com/dep" {
+					t.Errorf("unexpected packages: %q", capsErr.packages)
+				}
+			case errors.As(err, &flagErr):
+				if flagErr.flag != "granularity" || flagErr.feature != "-symbols" {
+					t.Errorf("unexpected unsupported flag: got:%s for %s want:granularity for -symbols", flagErr.flag, flagErr.feature)
+				}
+			default:
+				t.Errorf("unexpected error type %T: %v", err, err)
+			}
+		})
+	}
+}
+
+func TestInputErrorStatus(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"bad.clignore": "example.com/ok\nexample.com/(bad\n",
+		"cycle.cm":     "include cycle.cm\n",
+	}
+	for name, src := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for _, test := range []struct {
+		name       string
+		fn         func() error
+		wantSource string
+	}{
+		{
+			name: "ignore_pattern",
+			fn: func() error {
+				_, err := readIgnoreFile(filepath.Join(dir, "bad.clignore"))
+				return err
+			},
+			wantSource: filepath.Join(dir, "bad.clignore") + ":2",
+		},
+		{
+			name: "ignore_missing",
+			fn: func() error {
+				_, err := readIgnoreFile(filepath.Join(dir, "missing.clignore"))
+				return err
+			},
+			wantSource: "-ignore-file",
+		},
+		{
+			name: "capability_map_cycle",
+			fn: func() error {
+				_, _, err := flattenCapabilityMap(filepath.Join(dir, "cycle.cm"))
+				return err
+			},
+			wantSource: "-capability_map",
+		},
+		{
+			name: "capability_map_missing",
+			fn: func() error {
+				_, _, err := flattenCapabilityMap(filepath.Join(dir, "missing.cm"))
+				return err
+			},
+			wantSource: "-capability_map",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.fn()
+			var inErr *inputError
+			if !errors.As(err, &inErr) {
+				t.Fatalf("unexpected error type %T: %v", err, err)
+			}
+			if inErr.source != test.wantSource {
+				t.Errorf("unexpected source: got:%s want:%s", inErr.source, test.wantSource)
+			}
+			if got := errorStatus(err); got != invocationError {
+				t.Errorf("unexpected status: got:%d want:%d", got, invocationError)
+			}
+		})
+	}
+}
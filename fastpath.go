@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// unhashedInputs returns a description of the analysis inputs of the
+// module at root with cfg that are not covered by inputsHash, or the empty
+// string if there are none. The code in local replacement directories, in
+// the modules of a go.work workspace and in vendor/ is analysed but not
+// recorded in go.sum, so no analysis can be skipped when they are used.
+func unhashedInputs(cfg config, root string) (string, error) {
+	v, err := goEnv(cfg.environ(), "GOWORK")
+	if err != nil {
+		return "", err
+	}
+	if v[0] != "" && v[0] != "off" {
+		return "workspace " + v[0] + " is in use", nil
+	}
+	_, err = os.Stat(filepath.Join(root, "vendor"))
+	if err == nil {
+		return "vendor directory is present", nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	path := filepath.Join(root, "go.mod")
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	f, err := modfile.Parse(path, b, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range f.Replace {
+		if r.New.Version == "" {
+			return fmt.Sprintf("%s is replaced by local directory %s", r.Old.Path, r.New.Path), nil
+		}
+	}
+	return "", nil
+}
+
+// inputsHash returns a hash of the inputs to analysis of the module at root
+// with cfg that can affect the capabilities found: the go.mod and go.sum
+// files, the analysis configuration and GOFLAGS, the toolchain described
+// by meta, the capslock version, and the package clauses, build constraints
+// and imports of the Go files in the analysis root, including hidden
+// directories if they are analysed. Changes to the bodies of first-party Go
+// files do not change the hash, since first-party code is not analysed. The
+// hash is only complete if unhashedInputs finds no other inputs.
+func inputsHash(cfg config, root string, meta metadata) (string, error) {
+	h := sha256.New()
+	for _, name := range []string{"go.mod", "go.sum"} {
+		b, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d\n", name, len(b))
+		h.Write(b)
+	}
+	fmt.Fprintf(h, "go %s toolchain %s cgo %s experiment %s\n", meta.GoVersion, meta.Toolchain, meta.CGOEnabled, meta.GOExperiment)
+	fmt.Fprintf(h, "goos %s goarch %s cgo %q env %q\n", cfg.goos, cfg.goarch, cfg.cgo, cfg.env)
+	// GOFLAGS may change the build, for example with -tags or -mod.
+	goflags, err := goEnv(cfg.environ(), "GOFLAGS")
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "goflags %q\n", goflags[0])
+	if cfg.tags != "" {
+		fmt.Fprintf(h, "tags %s\n", cfg.tags)
+	}
+	if cfg.aggregate {
+		fmt.Fprintln(h, "aggregate")
+	}
+	fmt.Fprintf(h, "mod %t stdlib %t tests %t separate %t internal %t hidden %t generated %t depth %d large %d\n",
+		cfg.module, cfg.stdlib, cfg.tests, cfg.separateTests, cfg.includeInternal, cfg.includeHidden, cfg.excludeGenerated, cfg.maxDepth, cfg.skipLarge)
+	fmt.Fprintf(h, "prerelease %t classification %t group %s binary %t\n", cfg.ignorePrerelease, cfg.trackClassification, cfg.groupBy, cfg.perBinary)
+	for _, m := range cfg.ignore {
+		fmt.Fprintf(h, "ignore %s\n", m.re)
+	}
+	for _, m := range cfg.ignoreMod {
+		fmt.Fprintf(h, "ignore-module %s\n", m.re)
+	}
+	version, err := capslockVersion(cfg.environ())
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "capslock %s %q\n", version, cfg.extra)
+	// The custom capability map is hashed with its includes resolved
+	// but recorded under the name it was given.
+	for _, f := range [][2]string{{cfg.custom, cfg.customMap}, {cfg.budget, cfg.budget}} {
+		if f[0] == "" {
+			continue
+		}
+		sum, err := fileHash(f[1])
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "file %s %s\n", f[0], sum)
+	}
+	fmt.Fprintf(h, "builtin %t\n", !cfg.noBuiltin)
+
+	dir := root
+	if !cfg.module {
+		dir, err = os.Getwd()
+		if err != nil {
+			return "", err
+		}
+	}
+	err = hashImports(h, dir, cfg.includeHidden)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// inputsUnchanged returns whether the metadata of the lock at path records
+// the inputs hash, inputs, and the lock has not been changed since it was
+// written.
+func inputsUnchanged(path, inputs string) bool {
+	m, err := readMeta(metaPath(path))
+	if err != nil || m == nil || m.InputsHash != inputs || m.LockHash == "" {
+		return false
+	}
+	sum, err := fileHash(path)
+	return err == nil && sum == m.LockHash
+}
+
+// hashImports writes the name and header of each Go file in the module
+// tree rooted at dir to h. The header is the content of the file up to the
+// end of its imports, so it includes build constraints. Directories that
+// the go tool ignores, unless hidden is true, and nested modules are
+// skipped.
+func hashImports(h io.Writer, dir string, hidden bool) error {
+	fset := token.NewFileSet()
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if path != dir && (skipDir(dir, path, name) || !hidden && isHidden(name)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(name, ".go") || isHidden(name) {
+			return nil
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		f, err := parser.ParseFile(fset, path, src, parser.ImportsOnly)
+		end := len(src)
+		if err == nil {
+			end = fset.Position(f.Name.End()).Offset
+			if n := len(f.Imports); n != 0 {
+				end = fset.Position(f.Imports[n-1].End()).Offset
+			}
+		}
+		rel, _ := filepath.Rel(dir, path)
+		fmt.Fprintf(h, "%s %d\n", filepath.ToSlash(rel), end)
+		_, err = h.Write(src[:end])
+		return err
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// writeFile writes data to the named file, creating it if necessary. The
+// data is written to a temporary file in the same directory which is then
+// renamed into place, so an interrupted write does not leave a truncated
+// file at path.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	f, err := createAtomic(path, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		f.abort()
+		return err
+	}
+	return f.commit()
+}
+
+// atomicFile is a file that is written incrementally to a temporary file
+// in the same directory as path and renamed into place when committed.
+type atomicFile struct {
+	*os.File
+	path string
+	perm os.FileMode
+}
+
+// createAtomic returns an atomicFile that replaces the named file with
+// the given permissions when committed.
+func createAtomic(path string, perm os.FileMode) (*atomicFile, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{File: f, path: path, perm: perm}, nil
+}
+
+// commit syncs and closes the temporary file and renames it into place.
+// The temporary file is removed if any step fails.
+func (f *atomicFile) commit() error {
+	tmp := f.Name()
+	err := f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, f.perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, f.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// abort closes and removes the temporary file, leaving the named file
+// unchanged.
+func (f *atomicFile) abort() {
+	f.Close()
+	os.Remove(f.Name())
+}
@@ -2,9 +2,9 @@ module github.com/efd6/cl
 
 go 1.20
 
-require golang.org/x/tools v0.14.0
-
 require (
-	golang.org/x/mod v0.13.0 // indirect
-	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/mod v0.13.0
+	golang.org/x/tools v0.14.0
 )
+
+require golang.org/x/sys v0.13.0
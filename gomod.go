@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/go/packages"
+)
+
+// reviewedModules returns the modules required by the go.mod file in root
+// that carry a review comment, mapped to the text of the comment. A review
+// comment is a comment starting with "reviewed:" on the require line, or on
+// the line before it, in either the single line or the block form of the
+// require directive. It may follow other comments separated by a semicolon,
+// as in "// indirect; reviewed: vetted by security".
+func reviewedModules(root string) (map[string]string, error) {
+	path := filepath.Join(root, "go.mod")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := modfile.ParseLax(path, b, nil)
+	if err != nil {
+		return nil, err
+	}
+	reviewed := make(map[string]string)
+	for _, r := range f.Require {
+		if r.Syntax == nil {
+			continue
+		}
+		for _, c := range append(r.Syntax.Before, r.Syntax.Suffix...) {
+			for _, part := range strings.Split(strings.TrimPrefix(c.Token, "//"), ";") {
+				text, ok := strings.CutPrefix(strings.TrimSpace(part), "reviewed:")
+				if ok {
+					reviewed[r.Mod.Path] = strings.TrimSpace(text)
+				}
+			}
+		}
+	}
+	return reviewed, nil
+}
+
+// dropReviewedModules returns changes with the changes to packages of the
+// reviewed modules removed. The mods parameter maps package import paths to
+// their module; changes grouped by module are keyed by the module path. If
+// verbose is true the dropped changes are reported to stderr.
+func dropReviewedModules(changes []change, reviewed map[string]string, mods map[string]*packages.Module, verbose bool) []change {
+	kept := changes[:0]
+	for _, c := range changes {
+		mod := c.Package
+		if m := mods[c.Package]; m != nil {
+			mod = m.Path
+		}
+		review, ok := reviewed[mod]
+		if !ok {
+			kept = append(kept, c)
+			continue
+		}
+		if verbose {
+			fmt.Fprintf(os.Stderr, "ignoring changes in %s: %s reviewed in go.mod: %s\n", c.Package, mod, review)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isHidden returns whether the go tool ignores files and directories with
+// the given name when matching package patterns.
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// skipDir returns whether the directory at path, with the given name, is
+// excluded from the module tree rooted at dir even when hidden directories
+// are included. These are directories the go tool treats specially,
+// version control metadata and nested modules.
+func skipDir(dir, path, name string) bool {
+	switch name {
+	case "testdata", "vendor", ".git", ".hg", ".svn":
+		return true
+	}
+	if path == dir {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(path, "go.mod"))
+	return err == nil
+}
+
+// hiddenDirs returns the directories of the module tree rooted at dir that
+// contain Go files but are not matched by the dir/... package pattern
+// because they are in or below a directory whose name starts with '.' or
+// '_'.
+func hiddenDirs(dir string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if skipDir(dir, path, d.Name()) {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hidden := false
+		for _, elem := range strings.Split(filepath.ToSlash(rel), "/") {
+			if elem != "." && isHidden(elem) {
+				hidden = true
+				break
+			}
+		}
+		if !hidden {
+			return nil
+		}
+		files, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if !f.IsDir() && strings.HasSuffix(f.Name(), ".go") && !isHidden(f.Name()) {
+				dirs = append(dirs, path)
+				break
+			}
+		}
+		return nil
+	})
+	return dirs, err
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/execabs"
+)
+
+// hookInput is the JSON document written to the standard input of a
+// -hook-cmd program.
+type hookInput struct {
+	Version int          `json:"version"` // version of the hook contract, currently 1
+	Root    string       `json:"root"`    // root directory of the analysis
+	Changes []hookChange `json:"changes"`
+}
+
+// hookOutput is the JSON document a -hook-cmd program may write to its
+// standard output to select the failing changes.
+type hookOutput struct {
+	Changes []hookChange `json:"changes"`
+}
+
+// hookChange is a package capability change in the -hook-cmd contract.
+type hookChange struct {
+	Package         string   `json:"package"`
+	Module          string   `json:"module,omitempty"`
+	Version         string   `json:"version,omitempty"`
+	BaselineVersion string   `json:"baselineVersion,omitempty"`
+	Added           []string `json:"added,omitempty"`
+	Removed         []string `json:"removed,omitempty"`
+	Direct          []string `json:"direct,omitempty"`
+}
+
+// hookCmdVersion is the version of the -hook-cmd contract.
+const hookCmdVersion = 1
+
+// applyHookCmd runs the program prog in root with the changes as a
+// hookInput on its standard input and returns the changes it decides are
+// failing. If prog exits with status 0 and writes nothing, no change is
+// failing. If it exits with status 0 and writes a hookOutput, the changes
+// and capabilities it lists are failing; they must be among the given
+// changes. If it exits with status 1, all changes are failing. Any other
+// status, or invalid output, is an inputError.
+func applyHookCmd(prog, root string, changes []change) ([]change, error) {
+	in := hookInput{Version: hookCmdVersion, Root: root, Changes: []hookChange{}}
+	for _, c := range changes {
+		in.Changes = append(in.Changes, hookChange{
+			Package:         c.Package,
+			Module:          c.module,
+			Version:         c.moduleVersion,
+			BaselineVersion: c.from,
+			Added:           c.Added,
+			Removed:         c.Removed,
+			Direct:          c.Direct,
+		})
+	}
+	b, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	cmd := execabs.Command(prog)
+	cmd.Dir = root
+	cmd.Stdin = bytes.NewReader(b)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	err = run(cmd)
+	var exitErr *execabs.ExitError
+	switch {
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return changes, nil
+	case err != nil:
+		return nil, &inputError{source: "-hook-cmd", err: fmt.Errorf("%s: %w", prog, err)}
+	case len(bytes.TrimSpace(out.Bytes())) == 0:
+		return nil, nil
+	}
+	var decision hookOutput
+	dec := json.NewDecoder(&out)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&decision)
+	if err != nil {
+		return nil, &inputError{source: "-hook-cmd", err: fmt.Errorf("%s: invalid output: %w", prog, err)}
+	}
+	byPkg := make(map[string]hookChange, len(decision.Changes))
+	for _, d := range decision.Changes {
+		byPkg[d.Package] = d
+	}
+	var failing []change
+	for _, c := range changes {
+		d, ok := byPkg[c.Package]
+		if !ok {
+			continue
+		}
+		delete(byPkg, c.Package)
+		c.Added, err = selectCapabilities(c.Added, d.Added)
+		if err == nil {
+			c.Removed, err = selectCapabilities(c.Removed, d.Removed)
+		}
+		if err == nil {
+			c.Direct, err = selectCapabilities(c.Direct, d.Direct)
+		}
+		if err != nil {
+			return nil, &inputError{source: "-hook-cmd", err: fmt.Errorf("%s: invalid output: %s: %w", prog, c.Package, err)}
+		}
+		if len(c.Added)+len(c.Removed)+len(c.Direct) != 0 {
+			failing = append(failing, c)
+		}
+	}
+	for _, d := range decision.Changes {
+		if _, ok := byPkg[d.Package]; ok {
+			return nil, &inputError{source: "-hook-cmd", err: fmt.Errorf("%s: invalid output: no change to %s", prog, d.Package)}
+		}
+	}
+	return failing, nil
+}
+
+// selectCapabilities returns the capabilities of have that are in want, in
+// the order of have. It is an error for want to hold a capability that is
+// not in have.
+func selectCapabilities(have, want []string) ([]string, error) {
+	changed := make(map[string]bool, len(have))
+	for _, c := range have {
+		changed[c] = true
+	}
+	keep := make(map[string]bool, len(want))
+	for _, c := range want {
+		if !changed[c] {
+			return nil, fmt.Errorf("capability %s did not change", c)
+		}
+		keep[c] = true
+	}
+	var sel []string
+	for _, c := range have {
+		if keep[c] {
+			sel = append(sel, c)
+		}
+	}
+	return sel, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// matcher is an ignore pattern.
+type matcher struct {
+	re     *regexp.Regexp
+	reason string // reason given for the pattern, if any
+	source string // flag or file position the pattern was given in
+	hits   int    // number of times the pattern has matched
+}
+
+type matchers []*matcher
+
+// match returns the first matcher in m that matches s, or nil if none match.
+// The hit count of the returned matcher is incremented.
+func (m matchers) match(s string) *matcher {
+	for _, p := range m {
+		if p.re.MatchString(s) {
+			p.hits++
+			return p
+		}
+	}
+	return nil
+}
+
+// unused returns the matchers in m that have not matched.
+func (m matchers) unused() matchers {
+	var u matchers
+	for _, p := range m {
+		if p.hits == 0 {
+			u = append(u, p)
+		}
+	}
+	return u
+}
+
+// readIgnoreFile reads the ignore patterns in the file at path. The file
+// holds one pattern per line. Blank lines and lines starting with '#' are
+// ignored. A pattern may be followed by whitespace and a comment; if the
+// comment is of the form "# reason: text", text is recorded as the reason
+// for the pattern. An unreadable file or invalid pattern is an *inputError.
+func readIgnoreFile(path string) (matchers, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, &inputError{source: "-ignore-file", err: err}
+	}
+	defer f.Close()
+	var m matchers
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		p, reason := parseIgnoreLine(sc.Text())
+		if p == "" {
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, &inputError{source: fmt.Sprintf("%s:%d", path, n), err: err}
+		}
+		m = append(m, &matcher{re: re, reason: reason, source: fmt.Sprintf("%s:%d", path, n)})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, &inputError{source: path, err: err}
+	}
+	return m, nil
+}
+
+// parseIgnoreLine returns the pattern and reason held in an ignore file line.
+func parseIgnoreLine(line string) (pattern, reason string) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "#") {
+		return "", ""
+	}
+	for i, r := range line {
+		if r != '#' || i == 0 || (line[i-1] != ' ' && line[i-1] != '\t') {
+			continue
+		}
+		comment := strings.TrimSpace(line[i+1:])
+		if text, ok := strings.CutPrefix(comment, "reason:"); ok {
+			reason = strings.TrimSpace(text)
+		}
+		return strings.TrimSpace(line[:i]), reason
+	}
+	return line, ""
+}
+
+// changeIgnore suppresses reports of changes to a capability in the
+// packages matching a pattern.
+type changeIgnore struct {
+	pkg        *matcher
+	capability string
+}
+
+// changeIgnores returns the change ignores in s. Each is a package path
+// pattern and a capability separated by the last colon, for example
+// ".*/protobuf.*:CAPABILITY_REFLECT". The CAPABILITY_ prefix of the
+// capability name is optional.
+func (s set) changeIgnores() ([]changeIgnore, error) {
+	p := make([]string, 0, len(s))
+	for y := range s {
+		p = append(p, y)
+	}
+	sort.Strings(p)
+	ignores := make([]changeIgnore, 0, len(s))
+	for _, y := range p {
+		i := strings.LastIndex(y, ":")
+		if i < 0 || i == len(y)-1 {
+			return nil, fmt.Errorf("invalid change ignore %q: want PATTERN:CAPABILITY", y)
+		}
+		re, err := regexp.Compile(y[:i])
+		if err != nil {
+			return nil, err
+		}
+		capability := y[i+1:]
+		if !strings.HasPrefix(capability, "CAPABILITY_") {
+			capability = "CAPABILITY_" + capability
+		}
+		ignores = append(ignores, changeIgnore{pkg: &matcher{re: re}, capability: capability})
+	}
+	return ignores, nil
+}
+
+// dropIgnoredChanges returns changes with the capabilities matching any of
+// ignores removed, dropping changes that have nothing else. If verbose is
+// true the removed capabilities are reported to stderr.
+func dropIgnoredChanges(changes []change, ignores []changeIgnore, verbose bool) []change {
+	ignored := func(pkg, capability string) bool {
+		for _, ig := range ignores {
+			if ig.capability == capability && ig.pkg.re.MatchString(pkg) {
+				if verbose && ig.pkg.source != "" {
+					fmt.Fprintf(os.Stderr, "ignoring change to %s in %s: allowed at %s\n", capability, pkg, ig.pkg.source)
+				} else if verbose {
+					fmt.Fprintf(os.Stderr, "ignoring change to %s in %s: matched %s\n", capability, pkg, ig.pkg.re)
+				}
+				return true
+			}
+		}
+		return false
+	}
+	keep := func(pkg string, caps []string) []string {
+		kept := caps[:0:0]
+		for _, capability := range caps {
+			if !ignored(pkg, capability) {
+				kept = append(kept, capability)
+			}
+		}
+		return kept
+	}
+	kept := changes[:0]
+	for _, c := range changes {
+		c.Added = keep(c.Package, c.Added)
+		c.Removed = keep(c.Package, c.Removed)
+		c.Direct = keep(c.Package, c.Direct)
+		if len(c.Added) != 0 || len(c.Removed) != 0 || len(c.Direct) != 0 {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// importFormats are the valid output formats for import listings.
+var importFormats = map[string]bool{"text": true, "json": true, "csv": true}
+
+// importerFormats are the valid ways of naming importing packages in
+// import attribution.
+var importerFormats = map[string]bool{"path": true, "dir": true, "full": true}
+
+// importerName returns the name of pkg for attribution as an importer in the
+// given format: its import path, its directory relative to the root of its
+// module, or its full package ID, which distinguishes test variants. A
+// package with no module or files is named by its ID in the dir format. The
+// pkg must have been loaded with packages.NeedName for the path format.
+func importerName(pkg *packages.Package, format string) string {
+	switch format {
+	case "path":
+		return pkg.PkgPath
+	case "dir":
+		if pkg.Module == nil || pkg.Module.Dir == "" || len(pkg.GoFiles) == 0 {
+			break
+		}
+		rel, err := filepath.Rel(pkg.Module.Dir, filepath.Dir(pkg.GoFiles[0]))
+		if err != nil {
+			break
+		}
+		if rel == "." {
+			return rel
+		}
+		return "./" + filepath.ToSlash(rel)
+	}
+	return pkg.String()
+}
+
+// importEntry is an entry in a JSON import listing.
+type importEntry struct {
+	Path       string          `json:"path"`
+	ImportedBy []string        `json:"importedBy,omitempty"`
+	Module     *importedModule `json:"module,omitempty"`
+}
+
+// importedModule is the module providing an imported package.
+type importedModule struct {
+	Path    string          `json:"path"`
+	Version string          `json:"version,omitempty"`
+	Replace *importedModule `json:"replace,omitempty"`
+}
+
+// writeImports writes the import paths in imports to w in the given format.
+// The imps and mods parameters map import paths to the packages importing
+// them and to their module respectively. Module information is included in
+// JSON output if withVersions is true, and always in CSV output, where mods
+// must be populated.
+func writeImports(w io.Writer, format string, imports []string, imps map[string][]string, mods map[string]*packages.Module, withVersions bool) error {
+	switch format {
+	case "json":
+		entries := make([]importEntry, 0, len(imports))
+		for _, i := range imports {
+			e := importEntry{Path: i, ImportedBy: imps[i]}
+			if withVersions {
+				e.Module = moduleEntry(mods[i])
+			}
+			entries = append(entries, e)
+		}
+		b, err := json.MarshalIndent(entries, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"import_path", "module", "version", "stdlib", "imported_by_count"})
+		for _, i := range imports {
+			var path, version string
+			if m := mods[i]; m != nil {
+				path, version = m.Path, m.Version
+			}
+			// Only stdlib packages are provided by no module.
+			stdlib := mods[i] == nil
+			cw.Write([]string{i, path, version, fmt.Sprint(stdlib), fmt.Sprint(len(imps[i]))})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		for _, i := range imports {
+			_, err := fmt.Fprintln(w, i)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func moduleEntry(m *packages.Module) *importedModule {
+	if m == nil {
+		return nil
+	}
+	return &importedModule{Path: m.Path, Version: m.Version, Replace: moduleEntry(m.Replace)}
+}
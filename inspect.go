@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// inspect prints the capabilities of the single package with the import
+// path, path, as resolved by the module graph of the current module.
+func inspect(cfg config, path string) int {
+	loadCfg := &packages.Config{
+		Mode:       packages.NeedName | packages.NeedModule,
+		Env:        cfg.environ(),
+		BuildFlags: cfg.buildFlags(),
+	}
+	pkgs, err := loadPackages(loadCfg, path)
+	var loadErr *loadError
+	if errors.As(err, &loadErr) && loadErr.err == nil {
+		// The package errors are the result of the path given.
+		fmt.Fprintln(os.Stderr, err)
+		return invocationError
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errorStatus(err)
+	}
+	if len(pkgs) != 1 {
+		fmt.Fprintf(os.Stderr, "%s does not resolve to a single package\n", path)
+		return invocationError
+	}
+	pkg := pkgs[0]
+	switch m := pkg.Module; {
+	case m == nil:
+		fmt.Println(pkg.PkgPath)
+	case m.Replace != nil:
+		fmt.Printf("%s %s@%s => %s %s\n", pkg.PkgPath, m.Path, m.Version, m.Replace.Path, m.Replace.Version)
+	default:
+		fmt.Printf("%s %s@%s\n", pkg.PkgPath, m.Path, m.Version)
+	}
+	format := "" // Use capslock's default summary.
+	if cfg.verbose {
+		format = "verbose"
+	}
+	buf, err := capslock(cfg, []string{pkg.PkgPath}, format, "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errorStatus(err)
+	}
+	fmt.Print(buf)
+	return success
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// junitSuite is a JUnit XML test suite of capability checks.
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+// junitCase is the capability check of a single package.
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+// junitFailure describes the capability changes of a package.
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",cdata"`
+}
+
+// writeJUnit writes changes to w as a JUnit XML test suite with a test case
+// for each of the analysed units and each changed package. The test cases of
+// changed packages fail with the changes in text format as the failure.
+func writeJUnit(w io.Writer, units []string, changes []change) error {
+	byPkg := make(map[string]change)
+	names := append([]string(nil), units...)
+	for _, c := range changes {
+		byPkg[c.Package] = c
+		names = append(names, c.Package)
+	}
+	names = dedup(names)
+	suite := junitSuite{Name: "cl", Tests: len(names)}
+	for _, name := range names {
+		tc := junitCase{Name: name, Classname: "capabilities"}
+		if c, ok := byPkg[name]; ok {
+			var buf bytes.Buffer
+			err := writeText(&buf, []change{c})
+			if err != nil {
+				return err
+			}
+			tc.Failure = &junitFailure{Message: c.summary(), Type: "CapabilityChange", Text: buf.String()}
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	err = enc.Encode(suite)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
+
+// summary returns a one line description of the change.
+func (c change) summary() string {
+	var parts []string
+	for _, p := range []struct {
+		label string
+		caps  []string
+	}{
+		{"added", c.Added},
+		{"removed", c.Removed},
+		{"now direct", c.Direct},
+	} {
+		if len(p.caps) != 0 {
+			parts = append(parts, p.label+" "+strings.Join(p.caps, ", "))
+		}
+	}
+	return "capabilities changed: " + strings.Join(parts, "; ")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// tooLarge is the reason recorded in the lock for packages skipped by
+// -skip-large.
+const tooLarge = "too large"
+
+// markSkipped records the packages in skipped in r as not analysed because
+// they are too large.
+func markSkipped(r *capslockReport, skipped map[string]int) {
+	for p := range skipped {
+		r.PackageInfo = append(r.PackageInfo, packageInfo{Path: p, Skipped: tooLarge})
+	}
+}
+
+// skipped returns the packages recorded in r as skipped.
+func (r *capslockReport) skipped() map[string]bool {
+	s := make(map[string]bool)
+	for _, p := range r.PackageInfo {
+		if p.Skipped != "" {
+			s[p.Path] = true
+		}
+	}
+	return s
+}
+
+// without returns the capabilities in r excluding those of the packages in
+// pkgs.
+func (r *capslockReport) without(pkgs map[string]int) *capslockReport {
+	w := capslockReport{ModuleInfo: r.ModuleInfo, PackageInfo: r.PackageInfo}
+	for _, ci := range r.CapabilityInfo {
+		if _, ok := pkgs[ci.PackageDir]; !ok {
+			w.CapabilityInfo = append(w.CapabilityInfo, ci)
+		}
+	}
+	return &w
+}
+
+// compareSkipped warns about packages that have crossed the -skip-large
+// threshold since the baseline was written, and returns the baseline
+// without the capabilities of the packages in skipped, since they are
+// not analysed. Packages that were skipped in the baseline and are now
+// analysed are reported as capability changes by the comparison.
+func compareSkipped(baseline *capslockReport, skipped map[string]int, imports []string) *capslockReport {
+	was := baseline.skipped()
+	var now []string
+	for p := range skipped {
+		if !was[p] {
+			now = append(now, p)
+		}
+	}
+	sort.Strings(now)
+	for _, p := range now {
+		fmt.Fprintf(os.Stderr, "warning: %s is now skipped as too large (%d files); its baseline capabilities are not compared\n", p, skipped[p])
+	}
+	for _, p := range imports {
+		if was[p] {
+			fmt.Fprintf(os.Stderr, "warning: %s was skipped as too large in the baseline and is now analysed\n", p)
+		}
+	}
+	return baseline.without(skipped)
+}
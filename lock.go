@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// capslockReport is the JSON output of capslock -output json. It is also
+// the format of the lock file. Unknown fields are rejected when parsing so
+// that changes to the capslock output schema are noticed.
+type capslockReport struct {
+	CapabilityInfo []capabilityInfo `json:"capabilityInfo,omitempty"`
+	ModuleInfo     []moduleInfo     `json:"moduleInfo,omitempty"`
+	PackageInfo    []packageInfo    `json:"packageInfo,omitempty"`
+}
+
+// capabilityInfo is a single capability of a function in a package with an
+// example call path demonstrating the capability.
+type capabilityInfo struct {
+	PackageName    string     `json:"packageName,omitempty"`
+	Capability     string     `json:"capability,omitempty"`
+	DepPath        string     `json:"depPath,omitempty"`
+	Path           []function `json:"path,omitempty"`
+	PackageDir     string     `json:"packageDir,omitempty"`
+	CapabilityType string     `json:"capabilityType,omitempty"`
+
+	// Note is a human annotation of the package capability. It is not
+	// part of capslock's output and is preserved by cl when the lock is
+	// regenerated.
+	Note string `json:"note,omitempty"`
+
+	// Binaries is the set of first-party main packages whose transitive
+	// imports include the package. It is not part of capslock's output
+	// and is only recorded by lock -per-binary.
+	Binaries []string `json:"binaries,omitempty"`
+}
+
+// oldNotes returns the notes in the existing lock at path, warning if it
+// exists but cannot be read.
+func oldNotes(path string) map[capKey]string {
+	old, err := readReport(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "warning: could not read notes from existing lock: %v\n", err)
+		}
+		return nil
+	}
+	return old.notes()
+}
+
+// capKey is a package capability.
+type capKey struct {
+	pkg, capability string
+}
+
+// notes returns the notes in r keyed by package capability. If more
+// than one entry for a package capability has a note, the first is used.
+func (r *capslockReport) notes() map[capKey]string {
+	n := make(map[capKey]string)
+	for _, ci := range r.CapabilityInfo {
+		k := capKey{ci.PackageDir, ci.Capability}
+		if _, ok := n[k]; !ok && ci.Note != "" {
+			n[k] = ci.Note
+		}
+	}
+	return n
+}
+
+// applyNotes canonicalizes r and adds the notes in n to the first entry of
+// each matching package capability in r.
+func (r *capslockReport) applyNotes(n map[capKey]string) {
+	if len(n) == 0 {
+		return
+	}
+	r.canonicalize()
+	for i := range r.CapabilityInfo {
+		ci := &r.CapabilityInfo[i]
+		k := capKey{ci.PackageDir, ci.Capability}
+		if note, ok := n[k]; ok {
+			ci.Note = note
+			delete(n, k)
+		}
+	}
+}
+
+// accept canonicalizes r and adds note to the first entry of each package
+// capability in r that has no note.
+func (r *capslockReport) accept(note string) {
+	r.canonicalize()
+	noted := r.notes()
+	for i := range r.CapabilityInfo {
+		ci := &r.CapabilityInfo[i]
+		k := capKey{ci.PackageDir, ci.Capability}
+		if _, ok := noted[k]; !ok {
+			ci.Note = note
+			noted[k] = note
+		}
+	}
+}
+
+// function is a function in a call path.
+type function struct {
+	Name    string `json:"name,omitempty"`
+	Site    *site  `json:"site,omitempty"`
+	Package string `json:"package,omitempty"`
+}
+
+// site is the location of a call.
+type site struct {
+	Filename string `json:"filename,omitempty"`
+	Line     int64  `json:"line,omitempty,string"`
+	Column   int64  `json:"column,omitempty,string"`
+}
+
+// moduleInfo is a module that provides analysed packages.
+type moduleInfo struct {
+	Path    string `json:"path,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// Local is the local directory replacing the module when it was
+	// analysed from one. It is not part of capslock's output.
+	Local string `json:"local,omitempty"`
+}
+
+// packageInfo is an analysed package.
+type packageInfo struct {
+	Path         string   `json:"path,omitempty"`
+	IgnoredFiles []string `json:"ignoredFiles,omitempty"`
+
+	// Skipped is the reason the package was not analysed. It is not
+	// part of capslock's output.
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// canonicalize sorts all the slices in r that do not have a semantic order.
+func (r *capslockReport) canonicalize() {
+	sort.SliceStable(r.CapabilityInfo, func(i, j int) bool {
+		a, b := r.CapabilityInfo[i], r.CapabilityInfo[j]
+		switch {
+		case a.PackageDir != b.PackageDir:
+			return a.PackageDir < b.PackageDir
+		case a.Capability != b.Capability:
+			return a.Capability < b.Capability
+		default:
+			return a.DepPath < b.DepPath
+		}
+	})
+	sort.Slice(r.ModuleInfo, func(i, j int) bool {
+		a, b := r.ModuleInfo[i], r.ModuleInfo[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Version < b.Version
+	})
+	sort.Slice(r.PackageInfo, func(i, j int) bool {
+		return r.PackageInfo[i].Path < r.PackageInfo[j].Path
+	})
+	for _, p := range r.PackageInfo {
+		sort.Strings(p.IgnoredFiles)
+	}
+}
+
+// subset returns the capabilities and package information in r of the
+// packages in pkgs.
+func (r *capslockReport) subset(pkgs []string) *capslockReport {
+	want := packageSet(pkgs)
+	s := capslockReport{ModuleInfo: r.ModuleInfo}
+	for _, ci := range r.CapabilityInfo {
+		if want[ci.PackageDir] {
+			s.CapabilityInfo = append(s.CapabilityInfo, ci)
+		}
+	}
+	for _, pi := range r.PackageInfo {
+		if want[pi.Path] {
+			s.PackageInfo = append(s.PackageInfo, pi)
+		}
+	}
+	return &s
+}
+
+// packageSet returns the set of packages in pkgs.
+func packageSet(pkgs []string) map[string]bool {
+	set := make(map[string]bool, len(pkgs))
+	for _, p := range pkgs {
+		set[p] = true
+	}
+	return set
+}
+
+// byModule returns the capabilities in r with each package replaced by the
+// path of its module, so that capabilities are compared at module
+// granularity. The mods parameter maps package import paths to their
+// module; packages without a module are retained under their own path.
+func (r *capslockReport) byModule(mods map[string]*packages.Module) *capslockReport {
+	m := capslockReport{ModuleInfo: r.ModuleInfo}
+	for _, ci := range r.CapabilityInfo {
+		if mod := mods[ci.PackageDir]; mod != nil {
+			ci.PackageDir = mod.Path
+		}
+		m.CapabilityInfo = append(m.CapabilityInfo, ci)
+	}
+	return &m
+}
+
+// aggregate returns the union of the capabilities in r attributed to the
+// single unit name, so that capabilities are compared for the whole module
+// regardless of the packages that provide them. A capability is direct if
+// it is direct in any package, and keeps the first note it has. Package
+// information is kept; call paths and module information are not retained.
+func (r *capslockReport) aggregate(name string) *capslockReport {
+	index := make(map[string]int) // Index of the entry for each capability.
+	a := capslockReport{PackageInfo: r.PackageInfo}
+	for _, ci := range r.CapabilityInfo {
+		i, ok := index[ci.Capability]
+		if !ok {
+			i = len(a.CapabilityInfo)
+			index[ci.Capability] = i
+			a.CapabilityInfo = append(a.CapabilityInfo, capabilityInfo{PackageDir: name, Capability: ci.Capability})
+		}
+		e := &a.CapabilityInfo[i]
+		if ci.CapabilityType == direct || e.CapabilityType == "" {
+			e.CapabilityType = ci.CapabilityType
+		}
+		if e.Note == "" {
+			e.Note = ci.Note
+		}
+	}
+	a.canonicalize()
+	return &a
+}
+
+// merge returns the union of the reports in rs. Duplicate module and
+// package information is removed.
+func merge(rs ...*capslockReport) *capslockReport {
+	if len(rs) == 1 {
+		return rs[0]
+	}
+	var m capslockReport
+	seenMod := make(map[moduleInfo]bool)
+	seenPkg := make(map[string]bool)
+	for _, r := range rs {
+		m.CapabilityInfo = append(m.CapabilityInfo, r.CapabilityInfo...)
+		for _, mi := range r.ModuleInfo {
+			if !seenMod[mi] {
+				seenMod[mi] = true
+				m.ModuleInfo = append(m.ModuleInfo, mi)
+			}
+		}
+		for _, pi := range r.PackageInfo {
+			if !seenPkg[pi.Path] {
+				seenPkg[pi.Path] = true
+				m.PackageInfo = append(m.PackageInfo, pi)
+			}
+		}
+	}
+	return &m
+}
+
+// writeReport writes r to the file at path in canonical form. The
+// encoding is streamed to a temporary file that is renamed into place, so
+// that only one entry of the encoding, but all of r, is held in memory at
+// a time.
+func writeReport(path string, r *capslockReport) error {
+	f, err := createAtomic(path, 0o664)
+	if err != nil {
+		return err
+	}
+	err = streamReport(f, r)
+	if err != nil {
+		f.abort()
+		return err
+	}
+	return f.commit()
+}
+
+// encodeReport returns the canonical lock file encoding of r.
+func encodeReport(r *capslockReport) ([]byte, error) {
+	var buf bytes.Buffer
+	err := streamReport(&buf, r)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// streamReport canonicalizes r and writes its lock file encoding to w one
+// entry at a time. The output is identical to r indented with tabs by
+// json.MarshalIndent and terminated by a newline.
+func streamReport(w io.Writer, r *capslockReport) error {
+	r.canonicalize()
+	return writeSections(w, []lockSection{
+		{"capabilityInfo", len(r.CapabilityInfo), func(i int) ([]byte, error) { return encodeEntry(r.CapabilityInfo[i]) }},
+		{"moduleInfo", len(r.ModuleInfo), func(i int) ([]byte, error) { return encodeEntry(r.ModuleInfo[i]) }},
+		{"packageInfo", len(r.PackageInfo), func(i int) ([]byte, error) { return encodeEntry(r.PackageInfo[i]) }},
+	})
+}
+
+// lockSection is a field of the lock holding n entries, the ith of which
+// is encoded by entry.
+type lockSection struct {
+	name  string
+	n     int
+	entry func(i int) ([]byte, error)
+}
+
+// encodeEntry returns the encoding of v as an entry of a lock section.
+func encodeEntry(v any) ([]byte, error) {
+	return json.MarshalIndent(v, "\t\t", "\t")
+}
+
+// writeSections writes the lock file encoding of sections to w one entry
+// at a time.
+func writeSections(w io.Writer, sections []lockSection) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("{")
+	var sep bool
+	for _, s := range sections {
+		if s.n == 0 {
+			// The fields are omitted when empty.
+			continue
+		}
+		if sep {
+			bw.WriteString(",")
+		}
+		sep = true
+		fmt.Fprintf(bw, "\n\t%q: [", s.name)
+		for i := 0; i < s.n; i++ {
+			b, err := s.entry(i)
+			if err != nil {
+				return err
+			}
+			if i != 0 {
+				bw.WriteString(",")
+			}
+			bw.WriteString("\n\t\t")
+			bw.Write(b)
+		}
+		bw.WriteString("\n\t]")
+	}
+	if sep {
+		bw.WriteString("\n")
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// sameReports returns an error describing the first difference between
+// the lock file encodings of a and b, or nil if they are identical.
+func sameReports(a, b *capslockReport) error {
+	x, err := encodeReport(a)
+	if err != nil {
+		return err
+	}
+	y, err := encodeReport(b)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(x, y) {
+		return nil
+	}
+	xl := strings.Split(string(x), "\n")
+	yl := strings.Split(string(y), "\n")
+	for i := 0; ; i++ {
+		var l, m string
+		if i < len(xl) {
+			l = xl[i]
+		}
+		if i < len(yl) {
+			m = yl[i]
+		}
+		if l != m {
+			return fmt.Errorf("line %d differs: %q != %q", i+1, strings.TrimSpace(l), strings.TrimSpace(m))
+		}
+	}
+}
+
+// readReport reads a capslock JSON report from the file at path.
+func readReport(path string) (*capslockReport, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseReport(b)
+}
+
+// parseReport parses a capslock JSON report. It is the single parser of
+// lock data and reports, and is safe to call with arbitrary input: a
+// malformed report is an error giving the line and column of the problem
+// where it is known, rather than a report that later causes a failure. The
+// input must hold exactly one JSON object, and each capability must name
+// its package and capability.
+func parseReport(b []byte) (*capslockReport, error) {
+	var r capslockReport
+	err := decodeStrict(b, &r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid capslock report: %w", err)
+	}
+	err = r.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid capslock report: %w", err)
+	}
+	return &r, nil
+}
+
+// decodeStrict decodes the single JSON value in b into v, rejecting
+// unknown fields and trailing data. Errors give the line and column of
+// the problem where it is known.
+func decodeStrict(b []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err != nil {
+		return positioned(b, err)
+	}
+	off := dec.InputOffset()
+	_, err = dec.Token()
+	if err != io.EOF {
+		rest := b[off:]
+		off += int64(len(rest) - len(bytes.TrimLeft(rest, " \t\r\n")))
+		line, col := position(b, off)
+		return fmt.Errorf("%d:%d: unexpected data after JSON value", line, col)
+	}
+	return nil
+}
+
+// validate returns an error if a capability in r does not name its package
+// and capability.
+func (r *capslockReport) validate() error {
+	for i, ci := range r.CapabilityInfo {
+		switch {
+		case ci.PackageDir == "":
+			return fmt.Errorf("capabilityInfo[%d]: missing packageDir", i)
+		case ci.Capability == "":
+			return fmt.Errorf("capabilityInfo[%d]: missing capability", i)
+		}
+	}
+	return nil
+}
+
+// positioned returns err with the line and column in b of a JSON syntax or
+// type error prepended.
+func positioned(b []byte, err error) error {
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+		off       int64
+	)
+	switch {
+	case errors.As(err, &syntaxErr):
+		off = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		off = typeErr.Offset
+	default:
+		return err
+	}
+	line, col := position(b, off)
+	return fmt.Errorf("%d:%d: %w", line, col, err)
+}
+
+// position returns the one-based line and column of the byte at offset off
+// in b.
+func position(b []byte, off int64) (line, col int) {
+	if off > int64(len(b)) {
+		off = int64(len(b))
+	}
+	b = b[:off]
+	line = bytes.Count(b, []byte("\n")) + 1
+	return line, len(b) - bytes.LastIndexByte(b, '\n')
+}
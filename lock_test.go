@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func FuzzParseReport(f *testing.F) {
+	f.Add([]byte(`{"capabilityInfo":[{"packageDir":"example.com/a","capability":"CAPABILITY_FILES","capabilityType":"CAPABILITY_TYPE_DIRECT"}]}`))
+	f.Add([]byte(`{"packageInfo":[{"path":"example.com/a","skipped":"too large"}]}`))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		r, err := parseReport(b)
+		var v capslockReport
+		if json.Unmarshal(b, &v) != nil {
+			// Truncated reports, trailing data and wrong types.
+			if err == nil {
+				t.Fatalf("no error for malformed report %q", b)
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+		if r == nil {
+			t.Fatalf("nil report without error for %q", b)
+		}
+		for i, ci := range r.CapabilityInfo {
+			if ci.PackageDir == "" || ci.Capability == "" {
+				t.Errorf("capabilityInfo[%d] of %q is incomplete: %+v", i, b, ci)
+			}
+		}
+	})
+}
+
+func TestLockWriter(t *testing.T) {
+	batches := func() []*capslockReport {
+		return []*capslockReport{
+			{
+				CapabilityInfo: []capabilityInfo{
+					{PackageDir: "example.com/b", Capability: "CAPABILITY_NETWORK", DepPath: "b.F net.Dial"},
+					{PackageDir: "example.com/b", Capability: "CAPABILITY_FILES", DepPath: "b.G os.Open"},
+					{PackageDir: "example.com/b", Capability: "CAPABILITY_FILES", DepPath: "b.G os.Open", CapabilityType: direct},
+				},
+				ModuleInfo:  []moduleInfo{{Path: "example.com/b", Version: "v1.0.0"}, {Path: "example.com/a", Version: "v1.0.0"}},
+				PackageInfo: []packageInfo{{Path: "example.com/b", IgnoredFiles: []string{"z.go", "y.go"}}},
+			},
+			{
+				CapabilityInfo: []capabilityInfo{
+					{PackageDir: "example.com/a", Capability: "CAPABILITY_FILES", DepPath: "a.F os.Open"},
+					{PackageDir: "example.com/a", Capability: "CAPABILITY_EXEC", DepPath: "a.G exec.Command"},
+				},
+				ModuleInfo:  []moduleInfo{{Path: "example.com/a", Version: "v1.0.0"}},
+				PackageInfo: []packageInfo{{Path: "example.com/a"}, {Path: "example.com/b"}},
+			},
+			{PackageInfo: []packageInfo{{Path: "example.com/c", Skipped: tooLarge}}},
+		}
+	}
+	notes := func() map[capKey]string {
+		return map[capKey]string{{"example.com/b", "CAPABILITY_FILES"}: "reviewed"}
+	}
+	const accepted = "accepted"
+
+	want := merge(batches()...)
+	want.applyNotes(notes())
+	want.accept(accepted)
+	wantBytes, err := encodeReport(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "caps.lock")
+	w, err := newLockWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.notes = notes()
+	w.accept = accepted
+	for _, r := range batches() {
+		err = w.add(r)
+		if err != nil {
+			w.abort()
+			t.Fatal(err)
+		}
+	}
+	spill := w.spill.Name()
+	err = w.commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, wantBytes) {
+		t.Errorf("unexpected streamed lock:\ngot:\n%s\nwant:\n%s", got, wantBytes)
+	}
+	if _, err := os.Stat(spill); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("spill file %s not removed: %v", spill, err)
+	}
+}
+
+func FuzzParseStdlibLock(f *testing.F) {
+	f.Add([]byte(`{"go1.21":{"capabilityInfo":[{"packageDir":"os","capability":"CAPABILITY_FILES","capabilityType":"CAPABILITY_TYPE_DIRECT"}]}}`))
+	f.Add([]byte(`{}`))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		l, err := parseStdlibLock(b)
+		var v stdlibLock
+		if json.Unmarshal(b, &v) != nil {
+			// Truncated locks, trailing data and wrong types.
+			if err == nil {
+				t.Fatalf("no error for malformed stdlib lock %q", b)
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+		for key, r := range l {
+			if r == nil {
+				t.Fatalf("nil %s baseline without error for %q", key, b)
+			}
+			for i, ci := range r.CapabilityInfo {
+				if ci.PackageDir == "" || ci.Capability == "" {
+					t.Errorf("%s capabilityInfo[%d] of %q is incomplete: %+v", key, i, b, ci)
+				}
+			}
+		}
+	})
+}
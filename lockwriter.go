@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"sync"
+)
+
+// lockWriter writes a lock file from reports added as their batches are
+// analysed. Each entry is encoded when it is added and spilled to a
+// temporary file; only the keys needed to order the entries are held in
+// memory. The lock is written in canonical order when committed, so the
+// output is identical to that of writeReport for the merged reports.
+// Entries with equal keys are written in the order they were added. It is
+// safe to add reports concurrently.
+type lockWriter struct {
+	path string
+
+	// only is the set of packages whose entries are written to the
+	// lock. If it is nil, all packages are written.
+	only map[string]bool
+
+	// notes are the notes to add to the first entry of each matching
+	// package capability, as with applyNotes. If accept is not empty,
+	// it is added to the first entry of each package capability that
+	// has no note, as with the accept method.
+	notes  map[capKey]string
+	accept string
+
+	mu       sync.Mutex
+	spill    *os.File
+	buf      *bufio.Writer
+	off      int64
+	sections [3][]spilledEntry
+	noted    map[capKey]bool
+	seenMod  map[moduleInfo]bool
+	seenPkg  map[string]bool
+}
+
+// spilledEntry is the sort key of an entry of a lock section and the
+// location of its encoding in the spill file.
+type spilledEntry struct {
+	key [3]string
+	off int64
+	n   int
+}
+
+var lockSections = [3]string{"capabilityInfo", "moduleInfo", "packageInfo"}
+
+// newLockWriter returns a lockWriter for the lock file at path. The commit
+// or abort method of the returned writer must be called.
+func newLockWriter(path string) (*lockWriter, error) {
+	f, err := os.CreateTemp("", "cl-lock-*")
+	if err != nil {
+		return nil, err
+	}
+	return &lockWriter{
+		path:    path,
+		spill:   f,
+		buf:     bufio.NewWriter(f),
+		noted:   make(map[capKey]bool),
+		seenMod: make(map[moduleInfo]bool),
+		seenPkg: make(map[string]bool),
+	}, nil
+}
+
+// add spills the entries of r to w. Duplicate module and package
+// information is removed, as with merge. The entries of r are canonicalized
+// and notes are added to them.
+func (w *lockWriter) add(r *capslockReport) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	r.canonicalize()
+	for i := range r.CapabilityInfo {
+		ci := &r.CapabilityInfo[i]
+		if w.only != nil && !w.only[ci.PackageDir] {
+			continue
+		}
+		k := capKey{ci.PackageDir, ci.Capability}
+		if note, ok := w.notes[k]; ok {
+			ci.Note = note
+			delete(w.notes, k)
+		} else if w.accept != "" && ci.Note == "" && !w.noted[k] {
+			ci.Note = w.accept
+		}
+		if ci.Note != "" {
+			w.noted[k] = true
+		}
+		err := w.write(0, [3]string{ci.PackageDir, ci.Capability, ci.DepPath}, *ci)
+		if err != nil {
+			return err
+		}
+	}
+	for _, mi := range r.ModuleInfo {
+		if w.seenMod[mi] {
+			continue
+		}
+		w.seenMod[mi] = true
+		err := w.write(1, [3]string{mi.Path, mi.Version}, mi)
+		if err != nil {
+			return err
+		}
+	}
+	for _, pi := range r.PackageInfo {
+		if w.seenPkg[pi.Path] || (w.only != nil && !w.only[pi.Path]) {
+			continue
+		}
+		w.seenPkg[pi.Path] = true
+		err := w.write(2, [3]string{pi.Path}, pi)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write spills the encoding of v as an entry of the ith lock section with
+// the given sort key.
+func (w *lockWriter) write(i int, key [3]string, v any) error {
+	b, err := encodeEntry(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.buf.Write(b)
+	if err != nil {
+		return err
+	}
+	w.sections[i] = append(w.sections[i], spilledEntry{key: key, off: w.off, n: len(b)})
+	w.off += int64(len(b))
+	return nil
+}
+
+// commit writes the spilled entries to the lock file in canonical order
+// and removes the spill file.
+func (w *lockWriter) commit() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	defer w.remove()
+	err := w.buf.Flush()
+	if err != nil {
+		return err
+	}
+	var sections []lockSection
+	for i := range w.sections {
+		entries := w.sections[i]
+		sort.SliceStable(entries, func(i, j int) bool {
+			a, b := entries[i].key, entries[j].key
+			for k := range a {
+				if a[k] != b[k] {
+					return a[k] < b[k]
+				}
+			}
+			return false
+		})
+		sections = append(sections, lockSection{lockSections[i], len(entries), func(j int) ([]byte, error) {
+			b := make([]byte, entries[j].n)
+			_, err := w.spill.ReadAt(b, entries[j].off)
+			return b, err
+		}})
+	}
+	f, err := createAtomic(w.path, 0o664)
+	if err != nil {
+		return err
+	}
+	err = writeSections(f, sections)
+	if err != nil {
+		f.abort()
+		return err
+	}
+	return f.commit()
+}
+
+// abort removes the spill file, leaving the lock file unchanged.
+func (w *lockWriter) abort() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.remove()
+}
+
+func (w *lockWriter) remove() {
+	w.spill.Close()
+	os.Remove(w.spill.Name())
+}
+
+// packages returns the packages with entries spilled to w.
+func (w *lockWriter) packages() map[string]bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	p := make(map[string]bool, len(w.seenPkg))
+	for path := range w.seenPkg {
+		p[path] = true
+	}
+	for _, e := range w.sections[0] {
+		p[e.key[0]] = true
+	}
+	return p
+}
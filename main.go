@@ -7,12 +7,16 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"go/build"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"sort"
 	"strings"
+	"text/template"
+	"time"
 
 	"golang.org/x/sys/execabs"
 	"golang.org/x/tools/go/packages"
@@ -24,191 +28,1960 @@ const (
 	internalError = 1 << (iota - 1)
 	invocationError
 	capChangeError // capChangeError is the status code for a caps change.
+	budgetError    // budgetError is the status code for a capability budget violation.
 )
 
 func main() {
 	os.Exit(Main())
 }
 
+// commands are the cl subcommands. The first command line argument is
+// used as the command if it is one of these, otherwise the command is check.
+var commands = map[string]bool{
+	"check":   true,
+	"lock":    true,
+	"imports": true,
+	"inspect": true,
+	"preview": true,
+	"merge":   true,
+	"remote":  true,
+
+	"changelog":   true,
+	"lock-stdlib": true,
+	"classify":    true,
+	"report":      true,
+
+	"list-capabilities": true,
+}
+
 func Main() int {
+	command, args := "check", os.Args[1:]
+	if len(args) != 0 && commands[args[0]] {
+		command, args = args[0], args[1:]
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), `Usage of %[1]s:
+  %[1]s [check] [flags] [<baseline lock>]
+  %[1]s lock [flags]
+  %[1]s lock-stdlib [flags]
+  %[1]s imports [flags]
+  %[1]s inspect [flags] <import path>
+  %[1]s classify [flags] <import path>...
+  %[1]s report -by-capability [flags]
+  %[1]s preview [flags] <module path>@<version>
+  %[1]s merge <output lock> <input lock>...
+  %[1]s remote [flags] <module path>[@<version>]
+  %[1]s changelog -from <revision> [-to <revision>]
+  %[1]s list-capabilities
+
+`, os.Args[0])
+		flag.PrintDefaults()
+	}
 	lock := flag.Bool("lock", false, "write out a new lock file")
+	acceptCurrent := flag.Bool("accept-current", false, "write a new lock file noting every current capability as accepted, to start tracking changes from the current state")
+	summaryOnly := flag.Bool("summary-only", false, "when locking, write only caps.summary and leave the lock file unchanged")
+	verifyDeterministic := flag.Bool("verify-deterministic", false, "when locking, generate the lock twice and fail if the results differ")
 	module := flag.Bool("mod", true, "include the whole main module")
 	list := flag.Bool("imports", false, "list imports that would be analysed and then exit")
+	includeHidden := flag.Bool("include-hidden", false, "also analyse the imports of packages in directories starting with '.' or '_', which the go tool ignores")
+	includeInternal := flag.Bool("include-internal", false, "analyse first-party internal packages as if they were dependencies")
 	stdlib := flag.Bool("stdlib", false, "include stdlib packages in analysis")
+	compareStdlib := flag.Bool("compare-stdlib-version", false, "with stdlib, ignore stdlib capability changes that match the caps.stdlib.lock baseline for the current go version")
+	strictStdlib := flag.Bool("strict-stdlib", false, "record stdlib capabilities per go version in caps.stdlib.lock and warn when they change")
+	tests := flag.Bool("tests", false, "include imports of test files for the analysed GOOS and GOARCH")
 	verbose := flag.Bool("v", false, "print verbose output")
 	goos := flag.String("goos", "", "GOOS to use for analysis")
 	goarch := flag.String("goarch", "", "GOARCH to use for analysis")
+	tags := flag.String("tags", "", "comma-separated list of build tags to use for analysis")
+	envFile := flag.String("env-file", "", "file of KEY=VALUE environment variables to set for analysis")
+	cgo := flag.String("cgo", "", "CGO_ENABLED to use for analysis, 0 or 1 (default from the environment)")
+	toolchain := flag.String("toolchain", "", "GOTOOLCHAIN to pin for analysis, for example go1.21.13 (default from the environment)")
+	goexperiment := flag.String("goexperiment", "", "GOEXPERIMENT to use for analysis (default from the environment)")
 	custom := flag.String("capability_map", "", "use a custom capability map file")
 	noBuiltin := flag.Bool("disable_builtin", false, "disable the builtin capability mappings when using a custom capability map")
+	requireGo := flag.String("require-go", "", "minimum go toolchain version (X.Y) required for analysis")
+	tmpl := flag.String("template", "", "text/template file used to format capability changes instead of -format")
+	format := flag.String("format", "text", "output format for capability changes (text, compact, junit or osv-ish), imports (text, json or csv) or reports (markdown or json)")
+	importerFormat := flag.String("importer-format", "full", "how importing packages are named in attribution (path, dir or full)")
+	withVersions := flag.Bool("with-versions", false, "include module paths and versions in JSON import listings")
+	tree := flag.Bool("tree", false, "with imports, print the imports of first-party packages as a tree annotated with their capabilities")
+	byCapability := flag.Bool("by-capability", false, "with report, list the dependencies with each capability and the first-party packages that reach them")
+	excludeGenerated := flag.Bool("exclude-generated", false, "exclude imports used only by generated files")
+	diffContext := flag.Bool("diff-context", false, "report the complete current capability set of each changed package")
+	showSymbols := flag.Bool("symbols", false, "list the exported functions of changed packages that have each added capability")
+	perBinary := flag.Bool("per-binary", false, "attribute capabilities to the first-party main packages that import them and compare them by binary")
+	aggregateLock := flag.Bool("aggregate-lock", false, "lock and compare only the union of the capabilities of all packages, regardless of the packages that have them")
+	groupBy := flag.String("group-by", "package", "granularity of capability change reports (package or module)")
+	trackClassification := flag.Bool("track-classification", false, "report capabilities that change from transitive to direct")
+	ignorePrerelease := flag.Bool("ignore-prerelease", false, "ignore capability changes in dependencies at prerelease or pseudo-versions")
+	gomodReviews := flag.Bool("gomod-reviews", false, "ignore capability changes in modules whose go.mod require line has a \"reviewed:\" comment")
+	explain := flag.String("explain-imports", "", "print the shortest import chains from first-party packages to the given import path and then exit")
 	ignore := make(set)
 	flag.Var(ignore, "i", "imported package path patterns to ignore (allows multiple instances)")
-	flag.Parse()
+	ignoreModule := make(set)
+	flag.Var(ignoreModule, "ignore-module", "module paths whose packages are ignored (allows multiple instances)")
+	ignoreChange := make(set)
+	flag.Var(ignoreChange, "ignore-change", "PATTERN:CAPABILITY of a capability whose changes are ignored in packages matching the pattern (allows multiple instances)")
+	baseRef := flag.String("base-ref", "", "git revision whose merge base with HEAD the changed files are found from; only the dependencies of packages containing them are analysed")
+	changedFiles := flag.String("changed-files", "", "comma-separated files changed since the lock, relative to the git repository root; only the dependencies of packages containing them are analysed")
+	ignoreFile := flag.String("ignore-file", "", "file of imported package path patterns to ignore (default .clignore in the analysis root if it exists)")
+	warnUnused := flag.Bool("warn-unused-ignores", false, "warn about ignore patterns that match no imports")
+	errorUnused := flag.Bool("error-unused-ignores", false, "fail if any ignore pattern matches no imports")
+	showIgnored := flag.Bool("show-ignored", false, "list ignored imports with the pattern that matched them and then exit")
+	explainIgnores := flag.Bool("explain-ignores", false, "with imports, list ignored imports with the source and pattern that matched them and then exit")
+	var extra ordered
+	flag.Var(&extra, "capslock-arg", "additional argument to pass to capslock (allows multiple instances)")
+	from := flag.String("from", "", "git revision of the earlier lock for changelog")
+	to := flag.String("to", "HEAD", "git revision of the later lock for changelog")
+	baselinesFlag := flag.String("baselines", "", "comma-separated baseline locks to compare with, reporting the changes from each")
+	baselineURL := flag.String("baseline-url", "", "fetch the baseline lock from the given URL (authorization header from $CL_BASELINE_AUTHORIZATION)")
+	maxDepth := flag.Int("max-depth", 1, "analyse dependencies within this many imports of first-party packages")
+	skipLarge := flag.Int("skip-large", 0, "experimental: skip analysis of dependency packages with more than this many Go files (0 for no limit)")
+	batchSize := flag.Int("batch-size", 0, "maximum number of packages to analyse in each capslock invocation (0 for no limit)")
+	batchByModule := flag.Bool("batch-by-module", false, "analyse the packages of each module in separate capslock invocations")
+	jobs := flag.Int("jobs", 1, "maximum number of concurrent capslock invocations")
+	reviewRemovals := flag.Bool("review-removals", false, "report capability removals for acknowledgment in caps.reviewed without failing")
+	failOnWarnings := flag.Bool("fail-on-warnings", false, "fail if capslock writes warnings to stderr")
+	isolate := flag.Bool("isolate", false, "when capslock fails on a batch, analyse its packages individually to find the failing package")
+	hookCmd := flag.String("hook-cmd", "", "program that is given the capability changes of a check as JSON and decides which of them fail the check")
+	failFast := flag.Bool("fail-fast", false, "stop analysis and report only the first capability change found")
+	budget := flag.String("budget", "", "file of package patterns and their allowed capabilities to check")
+	hook := flag.Bool("hook", false, "run as a pre-commit hook: check only, write changes to stderr and write no files")
+	onCorrupt := flag.String("on-corrupt", "fail", "action when the baseline lock cannot be parsed (fail or regenerate)")
+	initMissing := flag.Bool("init-missing", false, "when checking without an existing lock, write an initial lock and succeed instead of failing")
+	separateTestLock := flag.Bool("separate-test-lock", false, "with tests, lock and compare the imports used only by tests in caps.test.lock instead of caps.lock")
+	strictMap := flag.Bool("strict-map", false, "fail if the capability map differs from the one recorded when the lock was written")
+	noFastPath := flag.Bool("no-fast-path", false, "always analyse, even if the analysis inputs are unchanged since the lock was written")
+	confirm := flag.Bool("confirm", false, "print a JSON confirmation line when a check finds no changes")
+	strict := flag.Bool("strict", false, "fail if no packages or imports are found to analyse")
+	minImports := flag.Int("min-imports", 0, "fail if fewer than this many imports remain to analyse after filtering")
+	writeConfig := flag.String("write-config", "", "write the effective analysis configuration as JSON to the given file")
+	cpuProfile := flag.String("cpuprofile", "", "write a CPU profile to the given file")
+	memProfile := flag.String("memprofile", "", "write a memory profile to the given file")
+	subprocTrace := flag.String("subproc-trace", "", "write a trace of subprocess start and end times to the given file")
+	dumpCapslock := flag.String("dump-capslock", "", "write the unmodified output of each capslock invocation to the given file")
+	db := flag.String("db", "", "append the package capabilities found by a check or lock to the given SQLite database, using the sqlite3 tool")
+	platformAgg := flag.String("platform-agg", "union", "how merge combines the capabilities of locks for different platforms (union or intersection)")
+	jsonlReport := flag.String("jsonl-report", "", "append a JSON line summarising each check to the given file")
+	successMarker := flag.String("success-marker", "", "when a check passes, write the git revision, time and analysis inputs hash to the given file")
+	metrics := flag.String("metrics", "", "write Prometheus text format gauges of the check to the given file")
+	flag.CommandLine.Parse(args)
+	if *acceptCurrent {
+		*lock = true
+	}
+	switch command {
+	case "lock":
+		*lock = true
+	case "imports":
+		*list = true
+	case "lock-stdlib":
+		*lock = true
+		*stdlib = true
+		*strictStdlib = true
+	case "report":
+		if *format == "text" {
+			// Reports are markdown documents by default.
+			*format = "markdown"
+		}
+	}
+	if *hook {
+		switch {
+		case command != "check" || *lock || *list:
+			fmt.Fprintln(os.Stderr, "hook is only valid for check")
+			return invocationError
+		case *writeConfig != "" || *cpuProfile != "" || *memProfile != "" || *subprocTrace != "" || *dumpCapslock != "" || *metrics != "" || *db != "" || *successMarker != "" || *jsonlReport != "" || *onCorrupt == "regenerate" || *initMissing:
+			fmt.Fprintln(os.Stderr, "hook does not allow file output")
+			return invocationError
+		}
+		*confirm = false
+	}
+	var baseline string
+	switch {
+	case command == "inspect":
+		if flag.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "inspect requires a single import path")
+			return invocationError
+		}
+	case command == "classify":
+		if flag.NArg() == 0 {
+			fmt.Fprintln(os.Stderr, "classify requires at least one import path")
+			return invocationError
+		}
+	case command == "preview" || command == "remote":
+		if flag.NArg() != 1 {
+			fmt.Fprintf(os.Stderr, "%s requires a single module query\n", command)
+			return invocationError
+		}
+	case command == "merge":
+		if flag.NArg() < 2 {
+			fmt.Fprintln(os.Stderr, "merge requires an output lock and at least one input lock")
+			return invocationError
+		}
+		switch *platformAgg {
+		case "union", "intersection":
+		default:
+			fmt.Fprintf(os.Stderr, "invalid platform-agg: %q\n", *platformAgg)
+			return invocationError
+		}
+		return mergeLocks(flag.Arg(0), flag.Args()[1:], *platformAgg == "intersection")
+	case command == "report" && (!*byCapability || *lock || *list):
+		fmt.Fprintln(os.Stderr, "report requires by-capability and does not allow lock or imports")
+		return invocationError
+	case command != "report" && *byCapability:
+		fmt.Fprintln(os.Stderr, "by-capability is only valid for report")
+		return invocationError
+	case *tree && (!*list || *format != "text" || *showIgnored || *explainIgnores):
+		fmt.Fprintln(os.Stderr, "tree is only valid for imports with text format")
+		return invocationError
+	case command == "changelog" && *from == "":
+		fmt.Fprintln(os.Stderr, "changelog requires a from revision")
+		return invocationError
+	case command == "check" && !*lock && !*list && flag.NArg() <= 1:
+		baseline = flag.Arg(0)
+		if baseline != "" && *baselineURL != "" {
+			fmt.Fprintln(os.Stderr, "baseline path and baseline-url are mutually exclusive")
+			return invocationError
+		}
+	case flag.NArg() != 0:
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %s\n", strings.Join(flag.Args(), " "))
+		return invocationError
+	}
+	var baselines []string
+	if *baselinesFlag != "" {
+		switch {
+		case command != "check" || *lock || *list:
+			fmt.Fprintln(os.Stderr, "baselines is only valid for check")
+			return invocationError
+		case baseline != "" || *baselineURL != "":
+			fmt.Fprintln(os.Stderr, "baselines, baseline path and baseline-url are mutually exclusive")
+			return invocationError
+		case *failFast || *reviewRemovals || *metrics != "" || *tmpl != "" || *format == "junit" || *format == "osv-ish":
+			fmt.Fprintln(os.Stderr, "baselines does not allow fail-fast, review-removals, metrics, template, junit or osv-ish format")
+			return invocationError
+		}
+		baselines = strings.Split(*baselinesFlag, ",")
+	}
+	if command == "list-capabilities" {
+		err := listCapabilities(os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return internalError
+		}
+		return success
+	}
+	for _, a := range extra {
+		if name, ok := managedFlag(a); ok {
+			fmt.Fprintf(os.Stderr, "capslock-arg: -%s is set by cl\n", name)
+			return invocationError
+		}
+	}
 	ignorer, err := ignore.regexps()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return invocationError
+	}
 	if *noBuiltin && *custom == "" {
 		fmt.Fprintln(os.Stderr, "disable_builtin requires capability_map")
 		return invocationError
 	}
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return invocationError
+	customMap := *custom
+	if *custom != "" {
+		flat, included, err := flattenCapabilityMap(*custom)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return errorStatus(err)
+		}
+		if included {
+			// Capslock is given the flattened map.
+			f, err := os.CreateTemp("", "cl-capability-map-*.cm")
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return internalError
+			}
+			defer os.Remove(f.Name())
+			_, err = f.Write(flat)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return internalError
+			}
+			customMap = f.Name()
+		}
+	}
+	changeIgnores, err := ignoreChange.changeIgnores()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return invocationError
+	}
+	var env []string
+	if *envFile != "" {
+		env, err = readEnvFile(*envFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return invocationError
+		}
+		for name, v := range map[string]*string{"GOOS": goos, "GOARCH": goarch, "CGO_ENABLED": cgo} {
+			if *v == "" {
+				*v, _ = lookupEnv(env, name)
+			}
+		}
+	}
+	switch *cgo {
+	case "", "0", "1":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid cgo setting: %q\n", *cgo)
+		return invocationError
+	}
+	formats := changeFormats
+	switch {
+	case *list:
+		formats = importFormats
+	case command == "report":
+		formats = reportFormats
+	}
+	if !formats[*format] {
+		fmt.Fprintf(os.Stderr, "invalid format: %q\n", *format)
+		return invocationError
+	}
+	if *db != "" && (command != "check" && command != "lock" || *list || *summaryOnly) {
+		fmt.Fprintln(os.Stderr, "db is only valid for check and lock")
+		return invocationError
+	}
+	if *platformAgg != "union" {
+		fmt.Fprintln(os.Stderr, "platform-agg is only valid for merge")
+		return invocationError
+	}
+	if *jsonlReport != "" && (command != "check" || *lock || *list) {
+		fmt.Fprintln(os.Stderr, "jsonl-report is only valid for check")
+		return invocationError
+	}
+	if *successMarker != "" && (command != "check" || *lock || *list) {
+		fmt.Fprintln(os.Stderr, "success-marker is only valid for check")
+		return invocationError
+	}
+	if *metrics != "" && (command != "check" || *lock || *list) {
+		fmt.Fprintln(os.Stderr, "metrics is only valid for check")
+		return invocationError
+	}
+	var changed []string
+	if *changedFiles != "" || *baseRef != "" {
+		switch {
+		case command != "check" || *lock || *list:
+			fmt.Fprintln(os.Stderr, "changed-files and base-ref are only valid for check")
+			return invocationError
+		case *changedFiles != "" && *baseRef != "":
+			fmt.Fprintln(os.Stderr, "changed-files and base-ref are mutually exclusive")
+			return invocationError
+		}
+		changed = []string{}
+		if *baseRef != "" {
+			changed, err = gitChangedFiles(*baseRef)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return invocationError
+			}
+		}
+		var files []string
+		for _, f := range strings.Split(*changedFiles, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				files = append(files, f)
+			}
+		}
+		files, err = repoPaths(files)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return invocationError
+		}
+		changed = append(changed, files...)
+		if needsFullAnalysis(changed) {
+			// Dependency resolution may have changed, so any dependency
+			// may be affected.
+			changed = nil
+		}
+	}
+	if *explainIgnores && !*list {
+		fmt.Fprintln(os.Stderr, "explain-ignores requires imports")
+		return invocationError
+	}
+	if *compareStdlib && (command != "check" || *lock || *list || !*stdlib || *strictStdlib) {
+		fmt.Fprintln(os.Stderr, "compare-stdlib-version is only valid for check with stdlib and without strict-stdlib")
+		return invocationError
+	}
+	if *verifyDeterministic && !*lock {
+		fmt.Fprintln(os.Stderr, "verify-deterministic requires lock")
+		return invocationError
+	}
+	if *summaryOnly && !*lock {
+		fmt.Fprintln(os.Stderr, "summary-only requires lock")
+		return invocationError
+	}
+	if !importerFormats[*importerFormat] {
+		fmt.Fprintf(os.Stderr, "invalid importer-format: %q\n", *importerFormat)
+		return invocationError
+	}
+	if *maxDepth < 1 {
+		fmt.Fprintf(os.Stderr, "invalid max-depth: %d\n", *maxDepth)
+		return invocationError
+	}
+	if *jobs < 1 {
+		fmt.Fprintf(os.Stderr, "invalid jobs: %d\n", *jobs)
+		return invocationError
+	}
+	if *skipLarge < 0 {
+		fmt.Fprintf(os.Stderr, "invalid skip-large: %d\n", *skipLarge)
+		return invocationError
+	}
+	if *minImports < 0 {
+		fmt.Fprintf(os.Stderr, "invalid min-imports: %d\n", *minImports)
+		return invocationError
+	}
+	switch *groupBy {
+	case "package", "module":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid group-by: %q\n", *groupBy)
+		return invocationError
+	}
+	var changeTemplate *template.Template
+	if *tmpl != "" {
+		changeTemplate, err = readTemplate(*tmpl)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return invocationError
+		}
+	}
+	switch *onCorrupt {
+	case "fail":
+	case "regenerate":
+		if baseline != "" || *baselineURL != "" || *baselinesFlag != "" {
+			fmt.Fprintln(os.Stderr, "on-corrupt regenerate only applies to the default lock")
+			return invocationError
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "invalid on-corrupt action: %q\n", *onCorrupt)
+		return invocationError
+	}
+	if *separateTestLock {
+		switch {
+		case !*tests:
+			fmt.Fprintln(os.Stderr, "separate-test-lock requires tests")
+			return invocationError
+		case *baselinesFlag != "" || *baselineURL != "" || *perBinary:
+			fmt.Fprintln(os.Stderr, "separate-test-lock does not allow baselines, baseline-url or per-binary")
+			return invocationError
+		case *failFast || *reviewRemovals || *metrics != "" || *tmpl != "" || *format == "junit" || *format == "osv-ish":
+			fmt.Fprintln(os.Stderr, "separate-test-lock does not allow fail-fast, review-removals, metrics, template, junit or osv-ish format")
+			return invocationError
+		}
+	}
+	if *hookCmd != "" {
+		switch {
+		case command != "check" || *lock || *list:
+			fmt.Fprintln(os.Stderr, "hook-cmd is only valid for check")
+			return invocationError
+		case *baselinesFlag != "" || *separateTestLock:
+			fmt.Fprintln(os.Stderr, "hook-cmd does not allow baselines or separate-test-lock")
+			return invocationError
+		}
+	}
+	if *strictMap && *baselineURL != "" {
+		fmt.Fprintln(os.Stderr, "strict-map requires a local baseline lock")
+		return invocationError
+	}
+	if *initMissing && (baseline != "" || *baselineURL != "" || *baselinesFlag != "") {
+		fmt.Fprintln(os.Stderr, "init-missing only applies to the default lock")
+		return invocationError
+	}
+	if *initMissing && *separateTestLock {
+		// Creating one missing lock would regenerate the other.
+		fmt.Fprintln(os.Stderr, "init-missing does not allow separate-test-lock")
+		return invocationError
+	}
+	if *reviewRemovals && (*format == "junit" || *format == "osv-ish") {
+		fmt.Fprintln(os.Stderr, "review-removals requires text or compact format")
+		return invocationError
+	}
+	if *perBinary && *groupBy != "package" {
+		fmt.Fprintln(os.Stderr, "per-binary and group-by are mutually exclusive")
+		return invocationError
+	}
+	if *aggregateLock && (*perBinary || *groupBy != "package" || *showSymbols || *failFast || *separateTestLock || *changedFiles != "" || *baseRef != "") {
+		fmt.Fprintln(os.Stderr, "aggregate-lock does not allow per-binary, group-by, symbols, fail-fast, separate-test-lock, changed-files or base-ref")
+		return invocationError
+	}
+	if *showSymbols && (*format != "text" || *groupBy != "package" || *perBinary) {
+		fmt.Fprintln(os.Stderr, "symbols requires text format and package granularity")
+		return invocationError
+	}
+	if *requireGo != "" {
+		if _, _, ok := majorMinor(*requireGo); !ok {
+			fmt.Fprintf(os.Stderr, "invalid go version: %q\n", *requireGo)
+			return invocationError
+		}
+	}
+	if *goos == "" {
+		*goos = runtime.GOOS
+	}
+	if *goarch == "" {
+		*goarch = runtime.GOARCH
+	}
+	stop, err := startProfiling(*cpuProfile, *memProfile, *subprocTrace)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return internalError
+	}
+	defer func() {
+		err := stop()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}()
+	if *dumpCapslock != "" {
+		stop, err := startDump(*dumpCapslock)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return internalError
+		}
+		defer func() {
+			err := stop()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+	}
+	cfg := config{
+		goos:        *goos,
+		goarch:      *goarch,
+		tags:        buildTags(*tags),
+		cgo:         *cgo,
+		experiment:  *goexperiment,
+		toolchain:   *toolchain,
+		env:         env,
+		changed:     changed,
+		baselines:   baselines,
+		ignore:      ignorer,
+		ignoreMod:   ignoreModule.modules(),
+		ignoreChg:   changeIgnores,
+		module:      *module,
+		list:        *list,
+		lock:        *lock,
+		summaryOnly: *summaryOnly,
+		reproduce:   *verifyDeterministic,
+		accept:      *acceptCurrent,
+		stdlib:      *stdlib,
+		tests:       *tests,
+
+		strictStdlib:    *strictStdlib,
+		stdlibOnly:      command == "lock-stdlib",
+		compareStdlib:   *compareStdlib,
+		includeInternal: *includeInternal,
+		includeHidden:   *includeHidden,
+		separateTests:   *separateTestLock,
+
+		verbose:   *verbose,
+		noBuiltin: *noBuiltin,
+		strictMap: *strictMap,
+		custom:    *custom,
+		customMap: customMap,
+		explain:   *explain,
+		extra:     extra,
+		requireGo: *requireGo,
+		format:    *format,
+		groupBy:   *groupBy,
+		symbols:   *showSymbols,
+		perBinary: *perBinary,
+		aggregate: *aggregateLock,
+		context:   *diffContext,
+		template:  changeTemplate,
+		importers: *importerFormat,
+
+		withVersions: *withVersions,
+		byCapability: *byCapability,
+		tree:         *tree,
+
+		ignorePrerelease: *ignorePrerelease,
+		gomodReviews:     *gomodReviews,
+
+		ignoreFile:     *ignoreFile,
+		showIgnored:    *showIgnored,
+		explainIgnores: *explainIgnores,
+		warnUnused:     *warnUnused,
+		errorUnused:    *errorUnused,
+
+		baselineURL: *baselineURL,
+		baseline:    baseline,
+		budget:      *budget,
+		onCorrupt:   *onCorrupt,
+		initMissing: *initMissing,
+		hookCmd:     *hookCmd,
+
+		writeConfig: *writeConfig,
+		metrics:     *metrics,
+		marker:      *successMarker,
+		jsonlReport: *jsonlReport,
+		db:          *db,
+
+		excludeGenerated: *excludeGenerated,
+		confirm:          *confirm,
+		noFastPath:       *noFastPath,
+		hook:             *hook,
+		batchSize:        *batchSize,
+		batchByModule:    *batchByModule,
+		jobs:             *jobs,
+		maxDepth:         *maxDepth,
+		skipLarge:        *skipLarge,
+		failFast:         *failFast,
+		isolate:          *isolate,
+		failOnWarnings:   *failOnWarnings,
+		reviewRemovals:   *reviewRemovals,
+		strict:           *strict,
+		minImports:       *minImports,
+
+		trackClassification: *trackClassification,
+	}
+	switch command {
+	case "inspect":
+		return inspect(cfg, flag.Arg(0))
+	case "classify":
+		return classify(cfg, flag.Args())
+	case "preview":
+		return preview(cfg, flag.Arg(0))
+	case "remote":
+		return remote(cfg, flag.Arg(0))
+	case "changelog":
+		return changelog(cfg, *from, *to)
+	}
+	if cfg.jsonlReport == "" {
+		return analyse(cfg)
+	}
+	cfg.run = newRunSummary(time.Now())
+	status := analyse(cfg)
+	err = appendRun(cfg.jsonlReport, cfg.run, status)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return status | internalError
+	}
+	return status
+}
+
+// config holds the analysis options.
+type config struct {
+	goos, goarch string
+	tags         string   // comma-separated build tags, sorted
+	cgo          string   // CGO_ENABLED value, empty for the environment default
+	experiment   string   // GOEXPERIMENT value, empty for the environment default
+	toolchain    string   // GOTOOLCHAIN value, empty for the environment default
+	env          []string // additional environment variables for analysis
+	changed      []string // changed files limiting the analysed dependencies
+	baselines    []string // paths of baseline locks to compare with together
+	ignore       matchers
+	ignoreMod    matchers       // module path matchers for ignored modules
+	ignoreChg    []changeIgnore // package capability changes to ignore
+
+	module      bool // analyse the whole main module
+	list        bool // list imports and exit
+	lock        bool // write a new lock file
+	summaryOnly bool // write only the summary when locking
+	reproduce   bool // generate the lock twice and require identical output
+	accept      bool // note all capabilities as accepted when locking
+	stdlib      bool // include stdlib imports
+	tests       bool // include test imports
+	verbose     bool
+
+	strictStdlib    bool // track stdlib capabilities separately
+	stdlibOnly      bool // write only the stdlib baseline for the toolchain
+	compareStdlib   bool // discount stdlib changes expected for the toolchain
+	includeInternal bool // analyse first-party internal packages
+	includeHidden   bool // load packages in directories the go tool ignores
+	separateTests   bool // lock test-only imports separately
+
+	custom    string // custom capability map path
+	customMap string // capability map passed to capslock, with includes resolved
+	noBuiltin bool   // disable builtin capability map
+	strictMap bool   // fail if the lock's capability map differs
+
+	explain string // import path to explain import chains for
+
+	extra []string // additional capslock arguments
+
+	requireGo string // minimum go toolchain version
+
+	format    string             // capability change or import listing output format
+	groupBy   string             // capability change granularity, package or module
+	symbols   bool               // list exported functions with added capabilities
+	perBinary bool               // attribute and compare capabilities by binary
+	aggregate bool               // lock and compare the union of all capabilities
+	context   bool               // report complete capability sets of changed packages
+	template  *template.Template // template for capability changes
+	importers string             // naming of importing packages in attribution, path, dir or full
+
+	withVersions bool // include module versions in import listings
+	byCapability bool // report the dependencies with each capability
+	tree         bool // list the imports as a tree annotated with capabilities
+
+	ignorePrerelease bool // ignore changes in prerelease and pseudo-version dependencies
+	gomodReviews     bool // ignore changes in modules with go.mod review comments
+
+	ignoreFile     string // file of ignore patterns
+	showIgnored    bool   // list ignored imports and exit
+	explainIgnores bool   // list ignored imports with the sources of their patterns and exit
+	warnUnused     bool   // warn about ignore patterns that match nothing
+	errorUnused    bool   // fail on ignore patterns that match nothing
+
+	baselineURL string // URL to fetch the baseline lock from
+	baseline    string // path of the baseline lock if not the default
+	budget      string // path of the capability budget file
+	onCorrupt   string // action for an unparseable baseline lock
+	initMissing bool   // write an initial lock if the baseline lock is missing
+	hookCmd     string // program deciding which capability changes fail
+
+	writeConfig string // path to write the effective configuration to
+	metrics     string // path to write check metrics to
+	marker      string // path of the success marker file
+	jsonlReport string // path of the JSONL run log
+	db          string // path of the capability history database
+
+	excludeGenerated bool // exclude imports only used by generated files
+	confirm          bool // print a confirmation on a clean check
+	noFastPath       bool // analyse even if inputs are unchanged
+	hook             bool // report to stderr for use as a pre-commit hook
+	batchSize        int  // maximum packages per capslock invocation
+	batchByModule    bool // analyse each module in its own batches
+	jobs             int  // maximum concurrent capslock invocations
+	maxDepth         int  // maximum import depth of analysed dependencies
+	skipLarge        int  // maximum Go files in an analysed dependency
+	failFast         bool // stop at the first capability change
+	isolate          bool // find the package causing a batch failure
+	failOnWarnings   bool // fail if capslock writes to stderr
+	reviewRemovals   bool // report removals for acknowledgment without failing
+	strict           bool // fail on an empty analysis set
+	minImports       int  // minimum number of imports to analyse
+
+	trackClassification bool // report transitive to direct capability changes
+
+	// expectedStdlib is the stdlib baseline for the current toolchain
+	// when stdlib changes are compared with it.
+	expectedStdlib *capslockReport
+	// stdlibCache caches the stdlib classifications of the analysis.
+	stdlibCache *stdlibCache
+	// reviewedMods holds the review comments of the modules accepted
+	// in go.mod when go.mod reviews are used.
+	reviewedMods map[string]string
+	// run collects the summary of a check when it is logged to the
+	// JSONL run log.
+	run *runSummary
+	// aggregateUnit is the path of the main module, which aggregated
+	// capabilities are attributed to.
+	aggregateUnit string
+	// dir is the directory capslock is run in, the current directory
+	// if empty.
+	dir string
+}
+
+// buildTags returns the comma-separated build tags in tags sorted and with
+// duplicates and empty tags removed, so that equivalent lists are recorded
+// identically.
+func buildTags(tags string) string {
+	var list []string
+	for _, t := range strings.Split(tags, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			list = append(list, t)
+		}
+	}
+	return strings.Join(dedup(list), ",")
+}
+
+// buildFlags returns the go command build flags for analysis.
+func (c config) buildFlags() []string {
+	if c.tags == "" {
+		return nil
+	}
+	return []string{"-tags=" + c.tags}
+}
+
+// loadConfig returns the configuration for loading packages for analysis
+// with their imports, module and files, resolving patterns in the
+// directory capslock is run in.
+func (c config) loadConfig() *packages.Config {
+	return &packages.Config{
+		Tests:      c.tests,
+		Mode:       packages.NeedName | packages.NeedImports | packages.NeedModule | packages.NeedFiles,
+		Env:        c.environ(),
+		BuildFlags: c.buildFlags(),
+		Dir:        c.dir,
+	}
+}
+
+// isStdlib returns whether p is a standard library package path for the
+// analysis, using the classifications cached by c.
+func (c config) isStdlib(p string) (bool, error) {
+	return c.stdlibCache.isStdlib(p, c.environ(), c.buildFlags()...)
+}
+
+// environ returns the environment for subprocesses run during analysis.
+func (c config) environ() []string {
+	env := append(os.Environ(), c.env...)
+	env = append(env,
+		"GOOS="+c.goos,
+		"GOARCH="+c.goarch,
+	)
+	if c.cgo != "" {
+		env = append(env, "CGO_ENABLED="+c.cgo)
+	}
+	if c.experiment != "" {
+		env = append(env, "GOEXPERIMENT="+c.experiment)
+	}
+	if c.toolchain != "" {
+		env = append(env, "GOTOOLCHAIN="+c.toolchain)
+	}
+	return env
+}
+
+// compare returns the reported capability changes from baseline to current
+// at the granularity requested by cfg. The mods parameter maps package import
+// paths to their module.
+func (c config) compare(baseline, current *capslockReport, mods map[string]*packages.Module) []change {
+	if c.perBinary {
+		// Binaries are first-party, so have no module to filter by.
+		baseline = baseline.byBinary()
+		current = current.byBinary()
+		mods = nil
+	}
+	if c.aggregate {
+		// The aggregate is not provided by any single module.
+		baseline = baseline.aggregate(c.aggregateUnit)
+		current = current.aggregate(c.aggregateUnit)
+		mods = nil
+	}
+	if c.groupBy == "module" {
+		baseline = baseline.byModule(mods)
+		current = current.byModule(mods)
+		byPath := make(map[string]*packages.Module)
+		for _, m := range mods {
+			byPath[m.Path] = m
+		}
+		mods = byPath
+	}
+	changes := c.filter(diff(baseline, current, c.trackClassification), mods)
+	if !c.context {
+		for i := range changes {
+			changes[i].current = nil
+		}
+	}
+	return changes
+}
+
+// units returns the units that capabilities are compared in for the
+// analysed imports: the imports themselves, their modules or the binaries
+// that import them, as grouped by c. The mods and reach parameters map
+// imports to their module and to the binaries that import them.
+func (c config) units(imports []string, mods map[string]*packages.Module, reach map[string][]string) []string {
+	var units []string
+	for _, imp := range imports {
+		switch {
+		case c.perBinary:
+			units = append(units, reach[imp]...)
+		case c.aggregate:
+			units = append(units, c.aggregateUnit)
+		case c.groupBy == "module" && mods[imp] != nil:
+			units = append(units, mods[imp].Path)
+		default:
+			units = append(units, imp)
+		}
+	}
+	return dedup(units)
+}
+
+// baselinePaths returns the paths of the baseline locks for analysis of
+// the module or tree at root.
+func (c config) baselinePaths(root string) []string {
+	if c.baselines != nil {
+		return c.baselines
+	}
+	path := c.baseline
+	if path == "" {
+		path = filepath.Join(root, "caps.lock")
+	}
+	if c.separateTests {
+		return []string{path, testLockPath(path)}
+	}
+	return []string{path}
+}
+
+// output returns the writer for check reports.
+func (c config) output() *os.File {
+	if c.hook {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// filter returns changes with the changes that cfg excludes from reporting
+// removed. The mods parameter maps package import paths to their module.
+func (c config) filter(changes []change, mods map[string]*packages.Module) []change {
+	if c.ignorePrerelease {
+		changes = dropPrerelease(changes, mods, c.verbose)
+	}
+	if len(c.ignoreChg) != 0 {
+		changes = dropIgnoredChanges(changes, c.ignoreChg, c.verbose)
+	}
+	if len(c.reviewedMods) != 0 {
+		changes = dropReviewedModules(changes, c.reviewedMods, mods, c.verbose)
+	}
+	if c.expectedStdlib != nil {
+		changes = dropExpectedStdlib(changes, c.expectedStdlib, c.isStdlib, c.verbose)
+	}
+	return changes
+}
+
+type set map[string]bool
+
+func (s set) Set(v string) error {
+	s[v] = true
+	return nil
+}
+
+func (s set) String() string {
+	p := make([]string, 0, len(s))
+	for y := range s {
+		p = append(p, y)
+	}
+	sort.Strings(p)
+	return strings.Join(p, ",")
+}
+
+func (s set) regexps() (matchers, error) {
+	p := make([]string, 0, len(s))
+	for y := range s {
+		p = append(p, y)
+	}
+	sort.Strings(p)
+	m := make(matchers, 0, len(s))
+	for _, y := range p {
+		re, err := regexp.Compile(y)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, &matcher{re: re, source: "-i"})
+	}
+	return m, nil
+}
+
+// modules returns matchers for the exact module paths in s.
+func (s set) modules() matchers {
+	p := make([]string, 0, len(s))
+	for y := range s {
+		p = append(p, y)
+	}
+	sort.Strings(p)
+	m := make(matchers, 0, len(s))
+	for _, y := range p {
+		m = append(m, &matcher{re: regexp.MustCompile("^" + regexp.QuoteMeta(y) + "$"), source: "-ignore-module"})
+	}
+	return m
+}
+
+// ordered is a flag.Value that allows multiple instances, retaining their order.
+type ordered []string
+
+func (l *ordered) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func (l *ordered) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, " ")
+}
+
+// managedFlag returns whether the command line argument a is a capslock
+// flag that is set by cl, and the name of the flag.
+func managedFlag(a string) (name string, ok bool) {
+	if !strings.HasPrefix(a, "-") {
+		return "", false
+	}
+	name = strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+	name, _, _ = strings.Cut(name, "=")
+	switch name {
+	case "output", "packages", "goos", "goarch":
+		return name, true
+	}
+	return "", false
+}
+
+// dedup returns s sorted with duplicate elements removed. The
+// backing array of s is reused.
+func dedup(s []string) []string {
+	if len(s) < 2 {
+		return s
+	}
+	sort.Strings(s)
+	i := 1
+	for _, v := range s[1:] {
+		if v != s[i-1] {
+			s[i] = v
+			i++
+		}
+	}
+	return s[:i]
+}
+
+func analyse(cfg config) int {
+	root, valid, err := moduleRoot()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if valid {
+			return invocationError
+		}
+		return internalError
+	}
+	if cfg.gomodReviews {
+		cfg.reviewedMods, err = reviewedModules(root)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return invocationError
+		}
+	}
+	if !cfg.module {
+		root, err = os.Getwd()
+		if err == nil {
+			root, err = filepath.EvalSymlinks(root)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return internalError
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return internalError
+	}
+
+	ignoreFile := cfg.ignoreFile
+	if ignoreFile == "" {
+		ignoreFile = filepath.Join(root, ".clignore")
+		if _, err := os.Stat(ignoreFile); err != nil {
+			ignoreFile = ""
+		}
+	}
+	if ignoreFile != "" {
+		m, err := readIgnoreFile(ignoreFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return errorStatus(err)
+		}
+		cfg.ignore = append(cfg.ignore, m...)
+	}
+
+	var meta metadata
+	if cfg.requireGo != "" || cfg.writeConfig != "" || !cfg.list && cfg.explain == "" && !cfg.showIgnored {
+		meta, err = analysisMeta(cfg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return internalError
+		}
+	}
+	if cfg.requireGo != "" {
+		major, minor, ok := majorMinor(meta.GoVersion)
+		reqMajor, reqMinor, _ := majorMinor(cfg.requireGo)
+		if !ok || major < reqMajor || (major == reqMajor && minor < reqMinor) {
+			fmt.Fprintf(os.Stderr, "go toolchain %s is older than required go%s\n", meta.GoVersion, strings.TrimPrefix(cfg.requireGo, "go"))
+			return invocationError
+		}
+	}
+	if cfg.writeConfig != "" {
+		rc, err := effectiveConfig(cfg, meta)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return internalError
+		}
+		err = writeRunConfig(cfg.writeConfig, rc)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return internalError
+		}
+	}
+
+	var initial bool // whether the lock is written as the initial baseline
+	if !cfg.list && cfg.explain == "" && !cfg.showIgnored && !cfg.byCapability {
+		unhashed, err := unhashedInputs(cfg, root)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return internalError
+		}
+		if unhashed == "" {
+			meta.InputsHash, err = inputsHash(cfg, root, meta)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return internalError
+			}
+		} else if cfg.verbose {
+			fmt.Fprintf(os.Stderr, "analysis cannot be skipped: %s\n", unhashed)
+		}
+		// JUnit reports list every analysed package, so they need the
+		// analysis even when nothing can have changed. Budgets are
+		// checked against first-party code, which is not hashed.
+		if meta.InputsHash != "" && !cfg.lock && !cfg.noFastPath && cfg.baselineURL == "" && cfg.baselines == nil && cfg.format != "junit" && cfg.metrics == "" && cfg.db == "" && cfg.budget == "" {
+			unchanged := true
+			for _, baselinePath := range cfg.baselinePaths(root) {
+				unchanged = unchanged && inputsUnchanged(baselinePath, meta.InputsHash)
+			}
+			if unchanged {
+				if cfg.verbose {
+					fmt.Fprintln(os.Stderr, "analysis inputs unchanged since the lock was written")
+				}
+				if cfg.confirm {
+					fmt.Println(`{"status":"ok","fastPath":true}`)
+				}
+				return markSuccess(cfg, root, meta)
+			}
+		}
+		if !cfg.lock && cfg.baselineURL == "" {
+			for _, baselinePath := range cfg.baselinePaths(root) {
+				_, err = readReport(baselinePath)
+				if errors.Is(err, fs.ErrNotExist) && cfg.initMissing {
+					// The first check establishes the baseline.
+					cfg.lock = true
+					initial = true
+					continue
+				}
+				var pathErr *fs.PathError
+				if err != nil && !errors.As(err, &pathErr) {
+					if cfg.onCorrupt != "regenerate" {
+						fmt.Fprintf(os.Stderr, "%s is corrupt: %v\nregenerate it with lock, or use -on-corrupt regenerate\n", baselinePath, err)
+						return invocationError
+					}
+					fmt.Fprintf(os.Stderr, "warning: %s is corrupt, regenerating: %v\n", baselinePath, err)
+					cfg.lock = true
+				}
+			}
+		}
+	}
+
+	loadCfg := cfg.loadConfig()
+	// The go command lists the dependencies of the packages regardless,
+	// so they are kept for their modules.
+	loadCfg.Mode |= packages.NeedDeps
+	// The go command must resolve the module from the same,
+	// symlink-resolved, root as the patterns.
+	loadCfg.Dir = root
+	patterns := []string{filepath.Join(root, "...")}
+	if cfg.includeHidden {
+		dirs, err := hiddenDirs(root)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return internalError
+		}
+		patterns = append(patterns, dirs...)
+	}
+	pkgs, err := loadPackages(loadCfg, patterns...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errorStatus(err)
+	}
+	if len(pkgs) == 0 {
+		if cfg.strict {
+			fmt.Fprintf(os.Stderr, "no packages found in %s\n", root)
+			return invocationError
+		}
+		fmt.Fprintf(os.Stderr, "warning: no packages found in %s\n", root)
+	}
+	warnConflicts(os.Stderr, pkgs)
+	if cfg.explain != "" {
+		chains := importChains(pkgs, cfg.explain)
+		if len(chains) == 0 {
+			fmt.Fprintf(os.Stderr, "%s is not imported\n", cfg.explain)
+			return invocationError
+		}
+		for _, c := range chains {
+			fmt.Println(strings.Join(c, " -> "))
+		}
+		return success
+	}
+
+	imps := make(map[string][]string)
+	mods := make(map[string]*packages.Module)
+	ignored := make(map[string]*matcher)
+	skipped := make(map[string]int) // Go file counts of too large packages.
+	// Imports are collected breadth first from the first-party packages
+	// to the maximum depth. Dependencies of ignored and stdlib packages
+	// are not followed.
+	firstParty := make(map[string]bool)
+	for _, pkg := range pkgs {
+		if pkg.Module != nil {
+			firstParty[pkg.Module.Path] = true
+		}
+	}
+	isFirstParty := func(imp string) bool {
+		for mod := range firstParty {
+			if imp == mod || strings.HasPrefix(imp, mod+"/") {
+				return !cfg.includeInternal || !isInternal(imp[len(mod):])
+			}
+		}
+		return false
+	}
+	seen := make(map[*packages.Package]bool)
+	// When test-only imports are locked separately, the imports and
+	// dependencies reached from non-test packages are recorded.
+	prod := make(map[string]bool)
+	prodDeps := make(map[*packages.Package]bool)
+	level := pkgs
+	if cfg.changed != nil {
+		level, err = affectedPackages(pkgs, cfg.changed)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return internalError
+		}
+		if cfg.verbose {
+			fmt.Fprintf(os.Stderr, "%d of %d packages affected by changed files\n", len(level), len(pkgs))
+		}
+	}
+	for depth := 1; depth <= cfg.maxDepth && len(level) != 0; depth++ {
+		var next []*packages.Package
+		for _, pkg := range level {
+			if depth == 1 && strings.HasSuffix(pkg.ID, ".test") {
+				// Skip the imports of synthesized test main packages.
+				continue
+			}
+			fromProd := prodDeps[pkg] || depth == 1 && !isTestVariant(pkg)
+			var used map[string]bool
+			if depth == 1 && cfg.excludeGenerated {
+				used, err = nonGeneratedImports(pkg)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					return internalError
+				}
+			}
+			for imp, dep := range pkg.Imports {
+				if isFirstParty(imp) {
+					continue
+				}
+				if used != nil && !used[imp] {
+					continue
+				}
+				if m := cfg.ignore.match(imp); m != nil {
+					ignored[imp] = m
+					continue
+				}
+				if dep.Module != nil {
+					if m := cfg.ignoreMod.match(dep.Module.Path); m != nil {
+						ignored[imp] = m
+						continue
+					}
+					if cfg.skipLarge > 0 && len(dep.GoFiles) > cfg.skipLarge {
+						skipped[imp] = len(dep.GoFiles)
+						if cfg.separateTests && fromProd {
+							prod[imp] = true
+						}
+						continue
+					}
+				}
+				imps[imp] = append(imps[imp], importerName(pkg, cfg.importers))
+				if dep.Module != nil {
+					mods[imp] = dep.Module
+				}
+				if cfg.separateTests && fromProd {
+					prod[imp] = true
+				}
+				if depth < cfg.maxDepth && (dep.Module != nil || cfg.stdlib) {
+					switch {
+					case !seen[dep]:
+						seen[dep] = true
+						next = append(next, dep)
+					case cfg.separateTests && fromProd && !prodDeps[dep]:
+						// A dependency first reached from tests is
+						// revisited as a non-test dependency.
+						next = append(next, dep)
+					}
+					if cfg.separateTests && fromProd {
+						prodDeps[dep] = true
+					}
+				}
+			}
+		}
+		level = next
+	}
+	for imp, by := range imps {
+		imps[imp] = dedup(by)
 	}
-	if *goos == "" {
-		*goos = runtime.GOOS
+	if !cfg.lock && !cfg.list && !cfg.showIgnored && !cfg.byCapability {
+		// Directives only filter the changes found by a check.
+		allowed, err := allowedImports(pkgs)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return errorStatus(err)
+		}
+		cfg.ignoreChg = append(cfg.ignoreChg, allowed...)
 	}
-	if *goarch == "" {
-		*goarch = runtime.GOARCH
+	if cfg.warnUnused || cfg.errorUnused {
+		unused := append(cfg.ignore.unused(), cfg.ignoreMod.unused()...)
+		for _, m := range unused {
+			if cfg.errorUnused {
+				fmt.Fprintf(os.Stderr, "ignore pattern %q matched no imports\n", m.re)
+			} else {
+				fmt.Fprintf(os.Stderr, "warning: ignore pattern %q matched no imports\n", m.re)
+			}
+		}
+		if len(unused) != 0 && cfg.errorUnused {
+			return invocationError
+		}
 	}
-	return analyse(*goos, *goarch, ignorer, *module, *list, *lock, *stdlib, *verbose, *noBuiltin, *custom)
-}
-
-type set map[string]bool
-
-func (s set) Set(v string) error {
-	s[v] = true
-	return nil
-}
-
-func (s set) String() string {
-	p := make([]string, 0, len(s))
-	for y := range s {
-		p = append(p, y)
+	if cfg.showIgnored || cfg.explainIgnores {
+		paths := make([]string, 0, len(ignored))
+		for imp := range ignored {
+			paths = append(paths, imp)
+		}
+		sort.Strings(paths)
+		for _, imp := range paths {
+			m := ignored[imp]
+			fields := []string{imp, m.re.String()}
+			if cfg.explainIgnores {
+				fields = []string{imp, m.source, m.re.String()}
+			}
+			if m.reason != "" {
+				fields = append(fields, m.reason)
+			}
+			fmt.Println(strings.Join(fields, "\t"))
+		}
+		return success
 	}
-	sort.Strings(p)
-	return strings.Join(p, ",")
-}
-
-func (s set) regexps() ([]*regexp.Regexp, error) {
-	re := make([]*regexp.Regexp, 0, len(s))
-	for p := range s {
-		r, err := regexp.Compile(p)
+	imports := make([]string, 0, len(imps))
+	var (
+		stdImports []string
+		cache      *stdlibCache
+	)
+	// std holds the stdlib imports, which are classified even when they
+	// are analysed, since capslock does not list them in its package
+	// information.
+	std := make(map[string]bool)
+	if len(imps) != 0 {
+		cache = openStdlibCache(cfg.environ())
+		cfg.stdlibCache = cache
+	}
+	for i, by := range imps {
+		isStd, err := cfg.isStdlib(i)
 		if err != nil {
-			return nil, err
+			fmt.Fprintf(os.Stderr, "%v: imported by %s\n", err, strings.Join(by, ","))
+			return internalError
+		}
+		if isStd {
+			std[i] = true
+			if !cfg.stdlib || cfg.strictStdlib {
+				if cfg.strictStdlib {
+					stdImports = append(stdImports, i)
+				}
+				continue
+			}
+		}
+		imports = append(imports, i)
+	}
+	cache.save(cfg.verbose)
+	sort.Strings(imports)
+	sort.Strings(stdImports)
+	var nonTestImports, testImports []string
+	if cfg.separateTests {
+		// Skipped packages are split too, so that each lock keeps the
+		// package information of its own packages.
+		pkgs := append([]string(nil), imports...)
+		for imp := range skipped {
+			pkgs = append(pkgs, imp)
 		}
-		re = append(re, r)
+		nonTestImports, testImports = splitTestImports(pkgs, prod)
 	}
-	return re, nil
-}
-
-type matchers []*regexp.Regexp
-
-func (m matchers) match(s string) bool {
-	for _, re := range m {
-		if re.MatchString(s) {
-			return true
+	for _, p := range [][]string{imports, stdImports} {
+		err = concrete(p)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return internalError
 		}
 	}
-	return false
-}
-
-func analyse(goos, goarch string, ignore matchers, module, list, lock, stdlib, verbose, noBuiltin bool, custom string) int {
-	root, valid, err := moduleRoot()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		if valid {
+	if len(imports) == 0 && len(pkgs) != 0 {
+		if cfg.strict {
+			fmt.Fprintln(os.Stderr, "no imports found to analyse")
 			return invocationError
 		}
-		return internalError
+		fmt.Fprintln(os.Stderr, "warning: no imports found to analyse")
 	}
-	if !module {
-		root, err = os.Getwd()
+	if len(imports) < cfg.minImports {
+		fmt.Fprintf(os.Stderr, "%d imports found to analyse, fewer than the minimum of %d: check the GOOS, GOARCH and ignore patterns\n", len(imports), cfg.minImports)
+		return invocationError
+	}
+	if cfg.list && !cfg.tree {
+		err = writeImports(os.Stdout, cfg.format, imports, imps, mods, cfg.withVersions)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			return internalError
 		}
+		return success
 	}
-
+	modList, err := buildList(root, cfg.environ())
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return internalError
 	}
-
-	cfg := &packages.Config{
-		Tests: false,
-		Mode:  packages.NeedImports | packages.NeedModule,
-		Env: append(os.Environ(),
-			"GOOS="+goos,
-			"GOARCH="+goarch,
-		),
-	}
-	pkgs, err := packages.Load(cfg, filepath.Join(root, "..."))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "load: %v\n", err)
-		return internalError
-	}
-	if packages.PrintErrors(pkgs) != 0 {
-		return internalError
+	meta.Replacements = replacements(modList)
+	cfg.aggregateUnit = mainModule(modList)
+	var reach map[string][]string
+	if cfg.perBinary {
+		reach = binaries(pkgs)
 	}
-
-	imps := make(map[string][]string)
-	for _, pkg := range pkgs {
-		for imp := range pkg.Imports {
-			if strings.HasPrefix(imp, pkg.Module.Path) {
-				continue
+	// generate analyses the imports and returns their merged report.
+	// eachBatch calls fn with the report of each batch as its analysis
+	// completes, and finally with the packages skipped as too large.
+	eachBatch := func(fn func(*capslockReport) error) error {
+		bs := cfg.batches(imports, mods)
+		runner := startBatches(cfg, bs, cfg.jobs)
+		defer runner.stop()
+		for i := 0; i <= len(bs); i++ {
+			var (
+				r   *capslockReport
+				err error
+			)
+			if i < len(bs) {
+				r, err = runner.wait(i)
+				if err != nil {
+					return err
+				}
+			} else if len(skipped) != 0 {
+				r = &capslockReport{}
+				markSkipped(r, skipped)
+			} else {
+				break
 			}
-			if ignore.match(imp) {
-				continue
+			canonicalizePaths(r, modList)
+			markLocalReplacements(r, modList)
+			if cfg.perBinary {
+				attribute(r, reach)
 			}
-			imps[imp] = append(imps[imp], pkg.String())
-		}
-	}
-	imports := make([]string, 0, len(imps))
-	for i, by := range imps {
-		if !stdlib {
-			isStd, err := isStdlib(i, goos, goarch)
+			err = fn(r)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "%v: imported by %s\n", err, strings.Join(by, ","))
-				return internalError
-			}
-			if isStd {
-				continue
+				return err
 			}
 		}
-		imports = append(imports, i)
+		return nil
+	}
+	generate := func() (*capslockReport, error) {
+		var reports []*capslockReport
+		err := eachBatch(func(r *capslockReport) error {
+			reports = append(reports, r)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return merge(reports...), nil
 	}
-	if list {
-		sort.Strings(imports)
-		for _, i := range imports {
-			fmt.Println(i)
+	if cfg.byCapability {
+		report, err := generate()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return errorStatus(err)
+		}
+		err = writeOwners(os.Stdout, cfg.format, owners(report, firstPartyReach(pkgs)))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return internalError
 		}
 		return success
 	}
-	if lock {
-		buf, err := capslock(goos, goarch, imports, "verbose", filepath.Join(root, "caps.summary"), custom, noBuiltin)
+	if cfg.tree {
+		report, err := generate()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return errorStatus(err)
+		}
+		err = writeTree(os.Stdout, pkgs, imports, report)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			return internalError
 		}
-		if verbose {
-			fmt.Println(buf)
+		return success
+	}
+	if cfg.stdlibOnly {
+		err = lockStdlib(cfg, filepath.Join(root, "caps.stdlib.lock"), stdlibKey(meta.GoVersion), stdImports)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return errorStatus(err)
+		}
+		return success
+	}
+	if cfg.lock {
+		buf, err := capslock(cfg, imports, "verbose", "")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return errorStatus(err)
 		}
-		_, err = capslock(goos, goarch, imports, "json", filepath.Join(root, "caps.lock"), custom, noBuiltin)
+		err = writeSummary(filepath.Join(root, "caps.summary"), buf.Bytes())
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			return internalError
 		}
+		if cfg.verbose {
+			fmt.Println(buf)
+		}
+		if cfg.summaryOnly {
+			return success
+		}
+		lockPath := filepath.Join(root, "caps.lock")
+		var note string
+		if cfg.accept {
+			note = "accepted with -accept-current on " + time.Now().Format("2006-01-02")
+		}
+		var report *capslockReport
+		// Without the need for the complete report, the lock is
+		// streamed from the batches as they are analysed.
+		if !cfg.reproduce && !cfg.aggregate && cfg.db == "" {
+			locks := map[string]map[string]bool{lockPath: nil}
+			if cfg.separateTests {
+				locks = map[string]map[string]bool{
+					lockPath:               packageSet(nonTestImports),
+					testLockPath(lockPath): packageSet(testImports),
+				}
+			}
+			var writers []*lockWriter
+			abort := func() {
+				for _, w := range writers {
+					w.abort()
+				}
+			}
+			for path, only := range locks {
+				w, err := newLockWriter(path)
+				if err != nil {
+					abort()
+					fmt.Fprintln(os.Stderr, err)
+					return internalError
+				}
+				writers = append(writers, w)
+				w.only = only
+				w.notes = oldNotes(path)
+				w.accept = note
+			}
+			err = eachBatch(func(r *capslockReport) error {
+				for _, w := range writers {
+					err := w.add(r)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				abort()
+				fmt.Fprintln(os.Stderr, err)
+				return errorStatus(err)
+			}
+			analysed := make(map[string]bool)
+			for _, w := range writers {
+				for p := range w.packages() {
+					analysed[p] = true
+				}
+			}
+			reportCoverage(analysed, imports, std, len(imps)-len(imports), len(ignored), len(skipped)).print(cfg.verbose)
+			for i, w := range writers {
+				err = w.commit()
+				if err != nil {
+					for _, w := range writers[i+1:] {
+						w.abort()
+					}
+					fmt.Fprintln(os.Stderr, err)
+					return internalError
+				}
+				err = writeLockMeta(w.path, &meta)
+				if err != nil {
+					for _, w := range writers[i+1:] {
+						w.abort()
+					}
+					fmt.Fprintln(os.Stderr, err)
+					return internalError
+				}
+			}
+		} else {
+			report, err = generate()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return errorStatus(err)
+			}
+			reportCoverage(report.packages(), imports, std, len(imps)-len(imports), len(ignored), len(skipped)).print(cfg.verbose)
+			if cfg.reproduce {
+				again, err := generate()
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					return errorStatus(err)
+				}
+				err = sameReports(report, again)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "lock is not deterministic: %v\n", err)
+					return internalError
+				}
+			}
+			locks := map[string]*capslockReport{lockPath: report}
+			if cfg.aggregate {
+				locks[lockPath] = report.aggregate(cfg.aggregateUnit)
+			}
+			if cfg.separateTests {
+				locks = map[string]*capslockReport{
+					lockPath:               report.subset(nonTestImports),
+					testLockPath(lockPath): report.subset(testImports),
+				}
+			}
+			for path, r := range locks {
+				r.applyNotes(oldNotes(path))
+				if cfg.accept {
+					r.accept(note)
+				}
+				err = writeReport(path, r)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					return internalError
+				}
+				err = writeLockMeta(path, &meta)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					return internalError
+				}
+			}
+		}
+		if cfg.db != "" {
+			err = recordRun(cfg.db, root, "lock", time.Now(), meta, report)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return internalError
+			}
+		}
+		if cfg.strictStdlib {
+			err = lockStdlib(cfg, filepath.Join(root, "caps.stdlib.lock"), stdlibKey(meta.GoVersion), stdImports)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return errorStatus(err)
+			}
+		}
+		if initial {
+			fmt.Printf("created initial baseline %s\n", lockPath)
+		}
 	} else {
-		buf, err := capslock(goos, goarch, imports, "compare", filepath.Join(root, "caps.lock"), custom, noBuiltin)
+		status := success
+		if cfg.budget != "" {
+			ok, err := checkBudgets(cfg.output(), cfg, cfg.budget)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return errorStatus(err)
+			}
+			if !ok {
+				status |= budgetError
+			}
+		}
+		if cfg.compareStdlib {
+			path := filepath.Join(root, "caps.stdlib.lock")
+			stdLock, err := readStdlibLock(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return internalError
+			}
+			key := stdlibKey(meta.GoVersion)
+			cfg.expectedStdlib = stdLock[key]
+			if cfg.expectedStdlib == nil {
+				fmt.Fprintf(os.Stderr, "warning: no stdlib capability baseline for %s in %s: stdlib changes are reported\n", key, path)
+			}
+		}
+		if cfg.strictStdlib {
+			err = checkStdlib(cfg, filepath.Join(root, "caps.stdlib.lock"), stdlibKey(meta.GoVersion), stdImports)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return errorStatus(err)
+			}
+		}
+		baselinePaths := cfg.baselinePaths(root)
+		baselines := make([]*capslockReport, len(baselinePaths))
+		for i, baselinePath := range baselinePaths {
+			var baseline *capslockReport
+			if cfg.baselineURL != "" {
+				baseline, err = fetchReport(cfg.baselineURL)
+			} else {
+				var lockMeta *metadata
+				lockMeta, err = readMeta(metaPath(baselinePath))
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					return internalError
+				}
+				if cfg.strictMap {
+					if lockMeta == nil {
+						fmt.Fprintf(os.Stderr, "%s has no metadata recording its capability map\n", baselinePath)
+						return invocationError
+					}
+					if diffs := lockMeta.mapMismatches(meta); len(diffs) != 0 {
+						fmt.Fprintf(os.Stderr, "%s\nregenerate the lock with lock if the capability map change is intended\n", strings.Join(diffs, "\n"))
+						return invocationError
+					}
+				}
+				for _, w := range lockMeta.mismatches(meta) {
+					fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+				}
+				baseline, err = readReport(baselinePath)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return internalError
+			}
+			if cfg.perBinary && !baseline.attributed() {
+				name := baselinePath
+				if cfg.baselineURL != "" {
+					name = cfg.baselineURL
+				}
+				fmt.Fprintf(os.Stderr, "%s has no per-binary attribution: regenerate it with lock -per-binary\n", name)
+				return invocationError
+			}
+			baseline = compareSkipped(baseline, skipped, imports)
+			if cfg.changed != nil {
+				// Only the dependencies of the affected packages were
+				// analysed, so compare only those.
+				baseline = baseline.subset(imports)
+			}
+			baselines[i] = baseline
+		}
+		baseline := baselines[0]
+		var reviewed map[capKey]bool
+		if cfg.reviewRemovals {
+			reviewed, err = readReviewed(filepath.Join(root, "caps.reviewed"))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return invocationError
+			}
+		}
+		var reports []*capslockReport
+		bs := cfg.batches(imports, mods)
+		runner := startBatches(cfg, bs, cfg.jobs)
+		defer runner.stop()
+		for i, b := range bs {
+			r, err := runner.wait(i)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return errorStatus(err)
+			}
+			canonicalizePaths(r, modList)
+			markLocalReplacements(r, modList)
+			if cfg.perBinary {
+				attribute(r, reach)
+			}
+			if cfg.failFast {
+				changes := cfg.compare(baseline.subset(b), r, mods)
+				if cfg.reviewRemovals {
+					changes, _ = splitRemovals(changes, reviewed)
+				}
+				if cfg.hookCmd != "" && len(changes) != 0 {
+					changes, err = applyHookCmd(cfg.hookCmd, root, changes)
+					if err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						return errorStatus(err)
+					}
+				}
+				if len(changes) != 0 {
+					cfg.run.record(1)
+					err = reportChanges(cfg, changes[:1], nil)
+					if err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						return errorStatus(err)
+					}
+					return status | capChangeError
+				}
+			}
+			reports = append(reports, r)
+		}
+		current := merge(reports...)
+		cov := reportCoverage(current.packages(), imports, std, len(imps)-len(imports), len(ignored), len(skipped))
+		cov.print(cfg.verbose)
+		if cfg.db != "" {
+			err = recordRun(cfg.db, root, "check", time.Now(), meta, current)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return internalError
+			}
+		}
+		if cfg.baselines != nil || cfg.separateTests {
+			currents := make([]*capslockReport, len(baselines))
+			for i := range currents {
+				currents[i] = current
+			}
+			if cfg.separateTests {
+				currents = []*capslockReport{current.subset(nonTestImports), current.subset(testImports)}
+			}
+			n, err := reportBaselines(cfg, baselinePaths, baselines, currents, mods)
+			cfg.run.record(n)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return errorStatus(err)
+			}
+			if n != 0 {
+				return status | capChangeError
+			}
+			if status != success {
+				return status
+			}
+			if cfg.confirm {
+				fmt.Printf("{\"status\":\"ok\",\"analyzed\":%d}\n", len(imports))
+			}
+			return markSuccess(cfg, root, meta)
+		}
+		changes := cfg.compare(baseline, current, mods)
+		var removals []capKey
+		if cfg.reviewRemovals {
+			changes, removals = splitRemovals(changes, reviewed)
+		}
+		if cfg.hookCmd != "" && len(changes) != 0 {
+			changes, err = applyHookCmd(cfg.hookCmd, root, changes)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return errorStatus(err)
+			}
+		}
+		if cfg.metrics != "" {
+			err = writeMetrics(cfg.metrics, current, cov, changes)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return internalError
+			}
+		}
+		if cfg.failFast && len(changes) > 1 {
+			changes = changes[:1]
+		}
+		cfg.run.record(len(changes))
+		err = reportChanges(cfg, changes, cfg.units(imports, mods, reach))
+		if err == nil {
+			err = writeRemovals(cfg.output(), "caps.reviewed", removals, len(changes) != 0)
+		}
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
-			return internalError
+			return errorStatus(err)
+		}
+		if len(changes) != 0 {
+			return status | capChangeError
+		}
+		if status != success {
+			return status
 		}
-		fmt.Print(buf)
-		if buf.Len() != 0 {
-			return capChangeError
+		if cfg.confirm {
+			fmt.Printf("{\"status\":\"ok\",\"analyzed\":%d}\n", len(imports))
 		}
+		return markSuccess(cfg, root, meta)
 	}
 	return success
 }
 
-// moduleRoot returns the root directory of the module in the current dir and
-// whether a go.mod file can be found. It returns an error if the go tool is
-// not running in module-aware mode.
+// reportBaselines writes the changes from each of the baselines, read
+// from the given paths, to the corresponding current report under a
+// heading naming the baseline, and returns the number of changes from
+// all baselines. The mods parameter maps package import paths to their
+// module.
+func reportBaselines(cfg config, paths []string, baselines, currents []*capslockReport, mods map[string]*packages.Module) (n int, err error) {
+	w := cfg.output()
+	for i, baseline := range baselines {
+		if i != 0 {
+			fmt.Fprintln(w)
+		}
+		changes := cfg.compare(baseline, currents[i], mods)
+		if len(changes) == 0 {
+			fmt.Fprintf(w, "No capability changes from %s.\n", paths[i])
+			continue
+		}
+		n += len(changes)
+		fmt.Fprintf(w, "Capability changes from %s:\n", paths[i])
+		err = reportChanges(cfg, changes, nil)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// reportChanges writes changes to the check output, followed by the
+// exported functions with each added capability if requested by cfg. The
+// units are the analysed packages, modules or binaries, as grouped by cfg,
+// and are reported in formats that list unchanged units.
+func reportChanges(cfg config, changes []change, units []string) error {
+	var err error
+	switch {
+	case cfg.template != nil:
+		err = writeTemplate(cfg.output(), cfg.template, changes)
+	case cfg.format == "junit":
+		err = writeJUnit(cfg.output(), units, changes)
+	default:
+		err = writeChanges(cfg.output(), cfg.format, changes)
+	}
+	if err != nil || !cfg.symbols {
+		return err
+	}
+	syms, err := symbols(cfg, changes)
+	if err != nil {
+		return err
+	}
+	writeSymbols(cfg.output(), changes, syms)
+	return nil
+}
+
+// importChains returns the shortest import chain from each first-party
+// package in pkgs to the package with the import path target. Chains do
+// not pass through other first-party packages, so each chain shows the
+// point where first-party code pulls in the path to target. The pkgs must
+// have been loaded with packages.NeedDeps.
+func importChains(pkgs []*packages.Package, target string) [][]string {
+	first := make(map[*packages.Package]bool)
+	for _, p := range pkgs {
+		first[p] = true
+	}
+	var chains [][]string
+	for _, p := range pkgs {
+		prev := map[*packages.Package]*packages.Package{p: nil}
+		queue := []*packages.Package{p}
+		for len(queue) != 0 {
+			n := queue[0]
+			queue = queue[1:]
+			if n.PkgPath == target {
+				var c []string
+				for ; n != nil; n = prev[n] {
+					c = append(c, n.PkgPath)
+				}
+				for i, j := 0, len(c)-1; i < j; i, j = i+1, j-1 {
+					c[i], c[j] = c[j], c[i]
+				}
+				chains = append(chains, c)
+				break
+			}
+			paths := make([]string, 0, len(n.Imports))
+			for path := range n.Imports {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			for _, path := range paths {
+				dep := n.Imports[path]
+				if _, seen := prev[dep]; seen || first[dep] {
+					continue
+				}
+				prev[dep] = n
+				queue = append(queue, dep)
+			}
+		}
+	}
+	sort.Slice(chains, func(i, j int) bool {
+		if len(chains[i]) != len(chains[j]) {
+			return len(chains[i]) < len(chains[j])
+		}
+		return strings.Join(chains[i], " ") < strings.Join(chains[j], " ")
+	})
+	return chains
+}
+
+// moduleRoot returns the root directory of the module in the current dir,
+// with symlinks resolved, and whether a go.mod file can be found. It
+// returns an error if the go tool is not running in module-aware mode.
 func moduleRoot() (root string, valid bool, err error) {
 	cmd := execabs.Command("go", "env", "GOMOD")
 	var buf, errBuf bytes.Buffer
 	cmd.Stdout = &buf
 	cmd.Stderr = &errBuf
-	err = cmd.Run()
+	err = run(cmd)
 	if err != nil {
 		return "", false, fmt.Errorf("go env %w: %v", err, &errBuf)
 	}
@@ -219,55 +1992,202 @@ func moduleRoot() (root string, valid bool, err error) {
 	if gomod == os.DevNull {
 		return "", true, errors.New("no go.mod")
 	}
-	return filepath.Dir(gomod), true, nil
+	// The root is resolved so that it matches the file paths of loaded
+	// packages, whether cl is run in the module through a symlink or not.
+	root, err = filepath.EvalSymlinks(filepath.Dir(gomod))
+	if err != nil {
+		return "", true, err
+	}
+	return root, true, nil
 }
 
-// isStdlibeturns whether p is a standard library package path.
-func isStdlib(p, goos, goarch string) (ok bool, err error) {
-	cmd := execabs.Command("go", "list", "-f={{.Standard}}", p)
-	cmd.Env = append(os.Environ(),
-		"GOOS="+goos,
-		"GOARCH="+goarch,
-	)
+// isStdlib returns whether p is a standard library package path when
+// listed with the provided environment and build flags.
+func isStdlib(p string, env []string, flags ...string) (ok bool, err error) {
+	args := append([]string{"list", "-f={{.Standard}}"}, flags...)
+	cmd := execabs.Command("go", append(args, p)...)
+	cmd.Env = env
 	var buf, errBuf bytes.Buffer
 	cmd.Stdout = &buf
 	cmd.Stderr = &errBuf
-	err = cmd.Run()
+	err = run(cmd)
 	if err != nil {
 		note, _, ok := strings.Cut(errBuf.String(), ";")
 		if ok {
 			return false, fmt.Errorf("go list %w: %s", err, note)
 		}
-		return false, fmt.Errorf("go list %w: %s", err, &errBuf)
+		return false, fmt.Errorf("go list %w: %s", err, strings.TrimSpace(errBuf.String()))
 	}
 	return strings.TrimSpace(buf.String()) == "true", nil
 }
 
-// capslock runs the capslock tool with the provided GOOS and GOARCH on pkgs.
-// If format is json or verbose, the output is written to a file at path. If
-// format is compare, the contents of the file at path are used as the
-// baseline for comparison.
-func capslock(goos, goarch string, pkgs []string, format, path, custom string, noBuiltin bool) (*bytes.Buffer, error) {
-	args := []string{"-goos", goos, "-goarch", goarch, "-output", format, "-packages", strings.Join(pkgs, ",")}
-	if format == "compare" {
-		args = append(args, path)
+// isInternal returns whether the module-relative package path rel is an
+// internal package path.
+func isInternal(rel string) bool {
+	return strings.HasSuffix(rel, "/internal") || strings.Contains(rel, "/internal/")
+}
+
+// batches splits pkgs into batches of at most n packages. If n is not
+// positive, pkgs is returned as a single batch.
+func batches(pkgs []string, n int) [][]string {
+	if n <= 0 || len(pkgs) <= n {
+		return [][]string{pkgs}
+	}
+	var b [][]string
+	for len(pkgs) > n {
+		b = append(b, pkgs[:n:n])
+		pkgs = pkgs[n:]
+	}
+	return append(b, pkgs)
+}
+
+// batches splits imports into the batches requested by c for analysis.
+// The mods parameter maps import paths to their module.
+func (c config) batches(imports []string, mods map[string]*packages.Module) [][]string {
+	if c.batchByModule {
+		return moduleBatches(imports, mods, c.batchSize)
+	}
+	return batches(imports, c.batchSize)
+}
+
+// analyseBatch runs capslock on the batch of packages, pkgs, and returns
+// the parsed JSON report. If capslock fails and cfg requests isolation,
+// each package is analysed on its own and the first package that fails
+// is reported in the returned error.
+func analyseBatch(cfg config, pkgs []string) (*capslockReport, error) {
+	r, err := capslockJSON(cfg, pkgs)
+	if err == nil || !cfg.isolate || len(pkgs) < 2 {
+		return r, err
+	}
+	fmt.Fprintf(os.Stderr, "capslock failed on a batch of %d packages, analysing individually\n", len(pkgs))
+	for _, p := range pkgs {
+		_, perr := capslockJSON(cfg, []string{p})
+		if perr != nil {
+			return nil, fmt.Errorf("analysing %s: %w", p, perr)
+		}
+	}
+	return nil, err
+}
+
+// concrete returns an error if any of pkgs is not a concrete import path.
+// Capslock expands package patterns itself, so only concrete paths are
+// passed to ensure that the packages it analyses are exactly the packages
+// that cl selected.
+func concrete(pkgs []string) error {
+	for _, p := range pkgs {
+		if p == "" || strings.Contains(p, "...") || strings.ContainsAny(p, ", ") || build.IsLocalImport(p) || filepath.IsAbs(p) {
+			return fmt.Errorf("internal error: %q is not a concrete import path", p)
+		}
+	}
+	return nil
+}
+
+// capslockJSON runs capslock on pkgs and returns the parsed JSON report.
+// An empty report is returned if pkgs is empty.
+func capslockJSON(cfg config, pkgs []string) (*capslockReport, error) {
+	buf, err := capslock(cfg, pkgs, "json", "")
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) == 0 {
+		return &capslockReport{}, nil
+	}
+	return parseReport(buf.Bytes())
+}
+
+// capslock runs the capslock tool with the GOOS, GOARCH and environment in
+// cfg on pkgs using the given output format. If path is not empty, the
+// output is also written to a file at path. Any extra arguments in cfg are
+// passed to capslock after the arguments managed by cl. Capslock is not run
+// if pkgs is empty and the output is empty.
+func capslock(cfg config, pkgs []string, format, path string) (*bytes.Buffer, error) {
+	args := []string{"-goos", cfg.goos, "-goarch", cfg.goarch, "-output", format, "-packages", strings.Join(pkgs, ",")}
+	if cfg.tags != "" {
+		args = append(args, "-buildtags", cfg.tags)
+	}
+	args = append(args, cfg.extra...)
+	if cfg.custom != "" {
+		args = append(args, "-capability_map", cfg.customMap)
+		if cfg.noBuiltin {
+			args = append(args, "-disable_builtin")
+		}
 	}
-	if custom != "" {
-		args = append(args, "capability_map", custom)
-		if noBuiltin {
-			args = append(args, "disable_builtin")
+	buf := new(bytes.Buffer)
+	var err error
+	if len(pkgs) != 0 {
+		var stderr *bytes.Buffer
+		buf, stderr, err = runCapslock(cfg.dir, args, cfg.environ())
+		var (
+			flagErr *unsupportedFlagError
+			capsErr *capslockError
+		)
+		if errors.As(err, &flagErr) {
+			flagErr.feature = flagFeature(cfg, flagErr.flag)
 		}
+		if errors.As(err, &capsErr) {
+			capsErr.packages = pkgs
+		}
+		if err != nil {
+			return nil, err
+		}
+		if cfg.failOnWarnings && len(bytes.TrimSpace(stderr.Bytes())) != 0 {
+			return nil, fmt.Errorf("capslock reported warnings: %s", bytes.TrimSpace(stderr.Bytes()))
+		}
+	}
+	if path != "" {
+		err = writeFile(path, buf.Bytes(), 0o664)
 	}
+	return buf, err
+}
+
+// runCapslock runs the capslock executable in dir with args in the
+// environment env and returns its standard output and standard error. It
+// is a variable to allow the capslock invocation to be replaced, for
+// example with a fake that returns canned output.
+var runCapslock = func(dir string, args, env []string) (stdout, stderr *bytes.Buffer, err error) {
 	cmd := execabs.Command("capslock", args...)
+	cmd.Dir = dir
+	cmd.Env = env
 	var buf, errBuf bytes.Buffer
 	cmd.Stdout = &buf
 	cmd.Stderr = &errBuf
-	err := cmd.Run()
+	err = run(cmd)
+	if dumper != nil {
+		dumper.record(args, buf.Bytes(), errBuf.Bytes(), err)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("capslock: %w: %v", err, &errBuf)
+		if m := undefinedFlag.FindSubmatch(errBuf.Bytes()); m != nil {
+			return nil, nil, &unsupportedFlagError{flag: string(m[1]), err: err}
+		}
+		capsErr := &capslockError{stderr: strings.TrimSpace(errBuf.String()), err: err}
+		var exitErr *execabs.ExitError
+		if errors.As(err, &exitErr) {
+			capsErr.code = exitErr.ExitCode()
+		}
+		return nil, nil, authHint(capsErr)
+	}
+	return &buf, &errBuf, nil
+}
+
+// undefinedFlag matches the flag package's report of a flag that the
+// capslock executable does not define.
+var undefinedFlag = regexp.MustCompile(`flag provided but not defined: -+([^\s=]+)`)
+
+// flagFeature returns the cl feature that causes the capslock flag name
+// to be passed with cfg, or the empty string if the flag is always passed.
+func flagFeature(cfg config, name string) string {
+	switch name {
+	case "granularity":
+		return "-symbols"
+	case "capability_map", "disable_builtin":
+		return "-" + name
+	case "buildtags":
+		return "-tags"
 	}
-	if format != "compare" {
-		err = os.WriteFile(path, buf.Bytes(), 0o664)
+	for _, arg := range cfg.extra {
+		if strings.TrimLeft(strings.SplitN(arg, "=", 2)[0], "-") == name {
+			return "-capslock-arg"
+		}
 	}
-	return &buf, err
+	return ""
 }
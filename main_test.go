@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeCapslockEnv is the environment variable that makes the test binary
+// behave as a fake capslock executable with the behaviour it names.
+const fakeCapslockEnv = "CL_FAKE_CAPSLOCK"
+
+func TestMain(m *testing.M) {
+	if mode, ok := os.LookupEnv(fakeCapslockEnv); ok {
+		os.Exit(fakeCapslock(mode))
+	}
+	os.Exit(m.Run())
+}
+
+// fakeCapslock runs the fake capslock behaviour mode and returns its exit
+// status.
+func fakeCapslock(mode string) int {
+	switch mode {
+	case "different":
+		fmt.Fprintln(os.Stderr, "capabilities differ")
+		return capslockDifferent
+	case "failed":
+		fmt.Fprintln(os.Stderr, "analysis failed")
+		return capslockFailed
+	case "undefined":
+		fmt.Fprintln(os.Stderr, "flag provided but not defined: -granularity")
+		return capslockFailed
+	case "signal":
+		p, err := os.FindProcess(os.Getpid())
+		if err == nil {
+			err = p.Kill()
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return capslockFailed
+	}
+	fmt.Fprintf(os.Stderr, "unknown fake capslock mode %q\n", mode)
+	return capslockFailed
+}
+
+// installFakeCapslock puts the test binary in $PATH as capslock and makes
+// the real runCapslock run it with the fake behaviour mode.
+func installFakeCapslock(t *testing.T, mode string) {
+	t.Helper()
+	pathCapslock(t)
+	orig := runCapslock
+	runCapslock = func(dir string, args, env []string) (*bytes.Buffer, *bytes.Buffer, error) {
+		return orig(dir, args, append(env, fakeCapslockEnv+"="+mode))
+	}
+	t.Cleanup(func() { runCapslock = orig })
+}
+
+// pathCapslock puts the test binary in $PATH as capslock, so that its
+// version can be found.
+func pathCapslock(t *testing.T) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dir := t.TempDir()
+	err = os.Symlink(exe, filepath.Join(dir, "capslock"))
+	if err != nil {
+		t.Skipf("cannot install fake capslock: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// stubCapslock replaces runCapslock for the duration of the test with fn,
+// and returns a pointer to the arguments of each invocation.
+func stubCapslock(t *testing.T, fn func(args []string) (stdout, stderr string, err error)) *[][]string {
+	t.Helper()
+	var calls [][]string
+	orig := runCapslock
+	runCapslock = func(_ string, args, env []string) (*bytes.Buffer, *bytes.Buffer, error) {
+		calls = append(calls, args)
+		stdout, stderr, err := fn(args)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bytes.NewBufferString(stdout), bytes.NewBufferString(stderr), nil
+	}
+	t.Cleanup(func() { runCapslock = orig })
+	return &calls
+}
+
+// cannedReport is a capslock JSON report of a single capability.
+const cannedReport = `{"capabilityInfo":[{"packageName":"dep","capability":"CAPABILITY_FILES","packageDir":"example.com/dep","capabilityType":"CAPABILITY_TYPE_DIRECT"}]}`
+
+func TestCapslockCanned(t *testing.T) {
+	calls := stubCapslock(t, func([]string) (string, string, error) {
+		return cannedReport, "", nil
+	})
+	cfg := config{goos: "linux", goarch: "amd64"}
+	path := filepath.Join(t.TempDir(), "out.json")
+	r, err := capslockJSON(cfg, []string{"example.com/dep"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []capabilityInfo{{
+		PackageName:    "dep",
+		Capability:     "CAPABILITY_FILES",
+		PackageDir:     "example.com/dep",
+		CapabilityType: direct,
+	}}
+	if !reflect.DeepEqual(r.CapabilityInfo, want) {
+		t.Errorf("unexpected capabilities:\ngot: %+v\nwant:%+v", r.CapabilityInfo, want)
+	}
+
+	_, err = capslock(cfg, []string{"example.com/dep"}, "json", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(b) != cannedReport {
+		t.Errorf("unexpected output file: got:%s want:%s", b, cannedReport)
+	}
+	if len(*calls) != 2 {
+		t.Errorf("unexpected number of capslock invocations: got:%d want:2", len(*calls))
+	}
+
+	_, err = capslockJSON(cfg, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(*calls) != 2 {
+		t.Error("capslock run without packages")
+	}
+}
+
+func TestCapslockWarnings(t *testing.T) {
+	stubCapslock(t, func([]string) (string, string, error) {
+		return cannedReport, "warning: something odd\n", nil
+	})
+	cfg := config{goos: "linux", goarch: "amd64"}
+	_, err := capslockJSON(cfg, []string{"example.com/dep"})
+	if err != nil {
+		t.Errorf("unexpected error without fail-on-warnings: %v", err)
+	}
+	cfg.failOnWarnings = true
+	_, err = capslockJSON(cfg, []string{"example.com/dep"})
+	if err == nil || !strings.Contains(err.Error(), "something odd") {
+		t.Errorf("unexpected error with fail-on-warnings: %v", err)
+	}
+}
+
+// copyFixture copies the module fixtures in testdata/mod to a temporary
+// directory and returns the directory of the example.com/app main module,
+// which depends on example.com/app-dep replaced by the sibling dep
+// directory. The sibling nodeps module has no dependencies.
+func copyFixture(t *testing.T) string {
+	t.Helper()
+	dst := t.TempDir()
+	err := filepath.WalkDir(filepath.Join("testdata", "mod"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(filepath.Join("testdata", "mod"), path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0o755)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), b, 0o644)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error copying fixture: %v", err)
+	}
+	return filepath.Join(dst, "app")
+}
+
+// runMain runs Main in dir with the command line arguments args and
+// returns its exit status.
+func runMain(t *testing.T, dir string, args ...string) int {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Setenv("PWD", dir)
+	origArgs, origFlags := os.Args, flag.CommandLine
+	os.Args = append([]string{"cl"}, args...)
+	flag.CommandLine = flag.NewFlagSet("cl", flag.ContinueOnError)
+	defer func() {
+		os.Args, flag.CommandLine = origArgs, origFlags
+		err := os.Chdir(wd)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}()
+	return Main()
+}
+
+// packagesArg returns the packages in the -packages argument of the
+// capslock arguments args.
+func packagesArg(args []string) []string {
+	p := argValue(args, "-packages")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, ",")
+}
+
+// analysed returns the packages analysed for the JSON reports of the
+// capslock invocations with the arguments in calls.
+func analysed(calls [][]string) []string {
+	var pkgs []string
+	for _, args := range calls {
+		if argValue(args, "-output") == "json" {
+			pkgs = append(pkgs, packagesArg(args)...)
+		}
+	}
+	return pkgs
+}
+
+// argValue returns the value of the flag name in args, or the empty string
+// if it is not present.
+func argValue(args []string, name string) string {
+	for i, a := range args {
+		if a == name && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// fakeAnalysis returns a capslock JSON report of a direct CAPABILITY_FILES
+// capability in each package in the -packages argument of args, and the
+// module information of their modules, found from the first two path
+// elements of the packages.
+func fakeAnalysis(args []string) (stdout, stderr string, err error) {
+	var r capslockReport
+	seen := make(map[string]bool)
+	for _, p := range packagesArg(args) {
+		r.CapabilityInfo = append(r.CapabilityInfo, capabilityInfo{
+			PackageName:    filepath.Base(p),
+			Capability:     "CAPABILITY_FILES",
+			PackageDir:     p,
+			CapabilityType: direct,
+		})
+		mod := strings.Join(strings.SplitN(p, "/", 3)[:2], "/")
+		if !seen[mod] {
+			seen[mod] = true
+			r.ModuleInfo = append(r.ModuleInfo, moduleInfo{Path: mod, Version: "v0.0.0"})
+		}
+	}
+	b, err := json.Marshal(r)
+	return string(b), "", err
+}
+
+func TestPackagesArgument(t *testing.T) {
+	dir := copyFixture(t)
+	calls := stubCapslock(t, fakeAnalysis)
+	status := runMain(t, dir, "lock", "-i", "example.com/app-dep/ignored")
+	if status != success {
+		t.Fatalf("unexpected exit status: %d", status)
+	}
+	got := analysed(*calls)
+	// The first-party and ignored packages are not analysed.
+	want := []string{"example.com/app-dep", "example.com/app-dep/sub"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected analysed packages: got:%q want:%q", got, want)
+	}
+	err := concrete(got)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTestsGOOS(t *testing.T) {
+	dir := copyFixture(t)
+	for _, test := range []struct {
+		args []string
+		want bool // whether the imports of foo_linux_test.go are analysed
+	}{
+		{args: []string{"-goos", "linux"}, want: false},
+		{args: []string{"-tests", "-goos", "linux"}, want: true},
+		{args: []string{"-tests", "-goos", "darwin"}, want: false},
+	} {
+		calls := stubCapslock(t, fakeAnalysis)
+		status := runMain(t, dir, append([]string{"lock"}, test.args...)...)
+		if status != success {
+			t.Fatalf("unexpected exit status for %q: %d", test.args, status)
+		}
+		var got bool
+		for _, p := range analysed(*calls) {
+			got = got || p == "example.com/app-dep/linuxtest"
+		}
+		if got != test.want {
+			t.Errorf("unexpected analysis of linux test imports for %q: got:%t want:%t", test.args, got, test.want)
+		}
+	}
+}
+
+func TestBudgetPatterns(t *testing.T) {
+	dir := copyFixture(t)
+	stubCapslock(t, fakeAnalysis)
+	status := runMain(t, dir, "lock", "-tests")
+	if status != success {
+		t.Fatalf("unexpected lock exit status: %d", status)
+	}
+	budget := filepath.Join(dir, "caps.budget")
+	for _, test := range []struct {
+		budget string
+		want   int
+		pkgs   []string // packages analysed for the budget
+	}{
+		{budget: "./... FILES\n", want: success, pkgs: []string{"example.com/app", "example.com/app/internal/util"}},
+		{budget: "./internal/... NETWORK\n", want: budgetError, pkgs: []string{"example.com/app/internal/util"}},
+		{budget: "./missing/... FILES\n", want: invocationError},
+	} {
+		err := os.WriteFile(budget, []byte(test.budget), 0o644)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		calls := stubCapslock(t, fakeAnalysis)
+		status := runMain(t, dir, "check", "-tests", "-budget", budget)
+		if status != test.want {
+			t.Errorf("unexpected exit status for %q: got:%d want:%d", test.budget, status, test.want)
+		}
+		var got []string
+		for _, args := range *calls {
+			pkgs := packagesArg(args)
+			// First-party packages are only analysed for budgets.
+			if len(pkgs) != 0 && (pkgs[0] == "example.com/app" || strings.HasPrefix(pkgs[0], "example.com/app/")) {
+				got = pkgs
+			}
+		}
+		if !reflect.DeepEqual(got, test.pkgs) {
+			t.Errorf("unexpected budget packages for %q: got:%q want:%q", test.budget, got, test.pkgs)
+		}
+	}
+}
+
+func TestLocalReplacement(t *testing.T) {
+	dir := copyFixture(t)
+	stubCapslock(t, fakeAnalysis)
+	status := runMain(t, dir, "lock")
+	if status != success {
+		t.Fatalf("unexpected exit status: %d", status)
+	}
+	r, err := readReport(filepath.Join(dir, "caps.lock"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// example.com/app-dep shares a path prefix with the main module but
+	// is not first-party.
+	var got []string
+	for _, ci := range r.CapabilityInfo {
+		got = append(got, ci.PackageDir)
+	}
+	want := []string{"example.com/app-dep", "example.com/app-dep/ignored", "example.com/app-dep/sub"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected locked packages: got:%q want:%q", got, want)
+	}
+	wantMods := []moduleInfo{{Path: "example.com/app-dep", Version: "v0.0.0", Local: "../dep"}}
+	if !reflect.DeepEqual(r.ModuleInfo, wantMods) {
+		t.Errorf("unexpected module information: got:%+v want:%+v", r.ModuleInfo, wantMods)
+	}
+}
+
+func TestSymlinkedModule(t *testing.T) {
+	dir := copyFixture(t)
+	link := filepath.Join(t.TempDir(), "link")
+	err := os.Symlink(filepath.Dir(dir), link)
+	if err != nil {
+		t.Skipf("cannot create symlink: %v", err)
+	}
+	calls := stubCapslock(t, fakeAnalysis)
+	status := runMain(t, filepath.Join(link, "app"), "lock")
+	if status != success {
+		t.Fatalf("unexpected exit status: %d", status)
+	}
+	got := analysed(*calls)
+	want := []string{"example.com/app-dep", "example.com/app-dep/ignored", "example.com/app-dep/sub"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected analysed packages: got:%q want:%q", got, want)
+	}
+	_, err = os.Stat(filepath.Join(dir, "caps.lock"))
+	if err != nil {
+		t.Errorf("lock not written: %v", err)
+	}
+
+	// A changed file named through the symlink is in a loaded package.
+	calls = stubCapslock(t, fakeAnalysis)
+	status = runMain(t, filepath.Join(link, "app"), "check", "-changed-files", filepath.Join(link, "app", "internal", "util", "util.go"))
+	if status != success {
+		t.Fatalf("unexpected exit status with changed files: %d", status)
+	}
+	got = analysed(*calls)
+	want = []string{"example.com/app-dep/ignored"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected analysed packages with changed files: got:%q want:%q", got, want)
+	}
+}
+
+func TestCapabilityMapArgs(t *testing.T) {
+	calls := stubCapslock(t, func([]string) (string, string, error) {
+		return cannedReport, "", nil
+	})
+	cfg := config{goos: "linux", goarch: "amd64", custom: "caps.map", customMap: "/tmp/resolved.map"}
+	for _, noBuiltin := range []bool{false, true} {
+		cfg.noBuiltin = noBuiltin
+		_, err := capslockJSON(cfg, []string{"example.com/dep"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// The map is passed as a flag argument with its includes resolved.
+	want := [][]string{
+		{"-goos", "linux", "-goarch", "amd64", "-output", "json", "-packages", "example.com/dep", "-capability_map", "/tmp/resolved.map"},
+		{"-goos", "linux", "-goarch", "amd64", "-output", "json", "-packages", "example.com/dep", "-capability_map", "/tmp/resolved.map", "-disable_builtin"},
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("unexpected capslock arguments:\ngot: %q\nwant:%q", *calls, want)
+	}
+}
+
+func TestFastPathBudget(t *testing.T) {
+	dir := filepath.Join(filepath.Dir(copyFixture(t)), "nodeps")
+	pathCapslock(t)
+	budget := filepath.Join(dir, "caps.budget")
+	err := os.WriteFile(budget, []byte("./... NETWORK\n"), 0o644)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stubCapslock(t, fakeAnalysis)
+	status := runMain(t, dir, "lock", "-budget", budget)
+	if status != success {
+		t.Fatalf("unexpected lock exit status: %d", status)
+	}
+	status = runMain(t, dir, "check")
+	if status != success {
+		t.Fatalf("unexpected check exit status: %d", status)
+	}
+	m, err := readMeta(metaPath(filepath.Join(dir, "caps.lock")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.InputsHash == "" {
+		t.Fatal("no inputs hash recorded")
+	}
+
+	// The inputs are unchanged, but the budget must still be checked.
+	calls := stubCapslock(t, fakeAnalysis)
+	status = runMain(t, dir, "check", "-budget", budget)
+	if status != budgetError {
+		t.Errorf("unexpected exit status for exceeded budget: got:%d want:%d", status, budgetError)
+	}
+	if len(*calls) == 0 {
+		t.Error("budget not analysed")
+	}
+}
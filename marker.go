@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// successMarker records a passing check for use as a CI cache key.
+type successMarker struct {
+	Revision   string `json:"revision,omitempty"`
+	Time       string `json:"time"`
+	InputsHash string `json:"inputsHash,omitempty"`
+}
+
+// markSuccess writes the success marker file requested by cfg, if any,
+// for a check of the module or tree at root that passed, and returns the
+// exit status of the check. The marker holds the git revision of root,
+// the current time and the analysis inputs hash in meta.
+func markSuccess(cfg config, root string, meta metadata) int {
+	if cfg.marker == "" {
+		return success
+	}
+	m := successMarker{
+		Revision:   gitRevision(root),
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		InputsHash: meta.InputsHash,
+	}
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err == nil {
+		err = writeFile(cfg.marker, append(b, '\n'), 0o664)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return internalError
+	}
+	return success
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mergeLocks writes the union of the capabilities in the lock files at
+// paths to a canonical lock at out. Package capabilities that are present
+// in only some of the locks that include the package are annotated with a
+// note naming the locks they were merged from, unless they already have a
+// note. If intersect is true, only the package capabilities present in
+// every lock are written instead, so that locks for different platforms
+// merge to the capabilities common to all of them.
+func mergeLocks(out string, paths []string, intersect bool) int {
+	reports := make([]*capslockReport, len(paths))
+	for i, p := range paths {
+		r, err := readReport(p)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return internalError
+		}
+		reports[i] = r
+	}
+	caps := make([]map[string]map[string]capabilityInfo, len(reports))
+	for i, r := range reports {
+		caps[i] = capabilities(r)
+	}
+	m := merge(reports...)
+
+	type entry struct {
+		pkg, capability, depPath string
+	}
+	seen := make(map[entry]bool)
+	kept := m.CapabilityInfo[:0]
+	for _, ci := range m.CapabilityInfo {
+		e := entry{ci.PackageDir, ci.Capability, ci.DepPath}
+		if seen[e] || intersect && !inAll(caps, ci.PackageDir, ci.Capability) {
+			continue
+		}
+		seen[e] = true
+		kept = append(kept, ci)
+	}
+	m.CapabilityInfo = kept
+
+	noted := m.notes()
+	notes := make(map[capKey]string)
+	for _, ci := range m.CapabilityInfo {
+		k := capKey{ci.PackageDir, ci.Capability}
+		if _, ok := noted[k]; ok {
+			continue
+		}
+		var have []string
+		var partial bool
+		for i, c := range caps {
+			pkgCaps, ok := c[ci.PackageDir]
+			if !ok {
+				continue
+			}
+			if _, ok := pkgCaps[ci.Capability]; ok {
+				have = append(have, filepath.Base(paths[i]))
+			} else {
+				partial = true
+			}
+		}
+		if partial {
+			notes[k] = "merged from " + strings.Join(have, ", ")
+		}
+	}
+	m.applyNotes(notes)
+	if intersect {
+		pkgs := m.PackageInfo[:0]
+		for _, pi := range m.PackageInfo {
+			if analysedByAll(reports, pi.Path) {
+				pkgs = append(pkgs, pi)
+			}
+		}
+		m.PackageInfo = pkgs
+	}
+
+	err := writeReport(out, m)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return internalError
+	}
+	return success
+}
+
+// inAll returns whether every capability set in caps has the named
+// capability for pkg.
+func inAll(caps []map[string]map[string]capabilityInfo, pkg, capability string) bool {
+	for _, c := range caps {
+		if _, ok := c[pkg][capability]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// analysedByAll returns whether every report in reports lists the
+// package pkg as analysed.
+func analysedByAll(reports []*capslockReport, pkg string) bool {
+	for _, r := range reports {
+		var found bool
+		for _, pi := range r.PackageInfo {
+			if pi.Path == pkg {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/execabs"
+)
+
+// metadata is the analysis environment recorded alongside a lock file.
+type metadata struct {
+	GoVersion  string `json:"goVersion,omitempty"`
+	CGOEnabled string `json:"cgoEnabled,omitempty"`
+	// Toolchain is the GOTOOLCHAIN setting that analysis was pinned to,
+	// if any. GoVersion is the toolchain it resolved to.
+	Toolchain string `json:"toolchain,omitempty"`
+	// GOExperiment is the effective GOEXPERIMENT setting, empty if no
+	// experiments differ from the toolchain's defaults.
+	GOExperiment string `json:"goExperiment,omitempty"`
+	// CapabilityMap is the SHA-256 hash of the custom capability map,
+	// with its includes resolved, if one was used. DisableBuiltin is
+	// whether the builtin capability mappings were disabled.
+	CapabilityMap  string `json:"capabilityMap,omitempty"`
+	DisableBuiltin bool   `json:"disableBuiltin,omitempty"`
+	// Tags is the sorted comma-separated list of build tags used, if
+	// any.
+	Tags string `json:"tags,omitempty"`
+	// Aggregate is whether the lock holds only the union of the
+	// capabilities of all packages.
+	Aggregate bool `json:"aggregate,omitempty"`
+
+	// Replacements is the set of module replacements in effect. Packages
+	// provided by replacement modules are recorded in the lock under the
+	// path of the module they replace.
+	Replacements []replacement `json:"replacements,omitempty"`
+
+	// InputsHash is the hash of the analysis inputs used to skip the
+	// analysis of a check when they have not changed since the lock was
+	// written.
+	InputsHash string `json:"inputsHash,omitempty"`
+	// LockHash is the hash of the lock file as written, so that edits
+	// to the lock also prevent analysis being skipped.
+	LockHash string `json:"lockHash,omitempty"`
+}
+
+// analysisMeta returns the metadata for analysis with cfg.
+func analysisMeta(cfg config) (metadata, error) {
+	v, err := goEnv(cfg.environ(), "GOVERSION", "CGO_ENABLED", "GOEXPERIMENT")
+	if err != nil {
+		return metadata{}, err
+	}
+	m := metadata{GoVersion: v[0], CGOEnabled: v[1], Toolchain: cfg.toolchain, GOExperiment: v[2], DisableBuiltin: cfg.noBuiltin, Tags: cfg.tags, Aggregate: cfg.aggregate}
+	if cfg.custom != "" {
+		m.CapabilityMap, err = fileHash(cfg.customMap)
+		if err != nil {
+			return metadata{}, err
+		}
+	}
+	return m, nil
+}
+
+// mismatches returns descriptions of differences between m, the metadata
+// of a lock, and the metadata of the current analysis that may result in
+// spurious capability changes. A nil m has no mismatches.
+func (m *metadata) mismatches(current metadata) []string {
+	if m == nil {
+		return nil
+	}
+	var diffs []string
+	if m.Toolchain != "" {
+		// A pinned toolchain is expected to be reproduced exactly.
+		if m.GoVersion != current.GoVersion {
+			diffs = append(diffs, fmt.Sprintf("lock was generated with toolchain %s pinned by GOTOOLCHAIN=%s but analysis is using %s", m.GoVersion, m.Toolchain, current.GoVersion))
+		}
+	} else if m.GoVersion != "" {
+		lockMajor, lockMinor, _ := majorMinor(m.GoVersion)
+		major, minor, _ := majorMinor(current.GoVersion)
+		if lockMajor != major || lockMinor != minor {
+			diffs = append(diffs, fmt.Sprintf("lock was generated with %s but analysis is using %s", m.GoVersion, current.GoVersion))
+		}
+	}
+	if m.CGOEnabled != "" && m.CGOEnabled != current.CGOEnabled {
+		diffs = append(diffs, fmt.Sprintf("lock was generated with CGO_ENABLED=%s but analysis is using CGO_ENABLED=%s", m.CGOEnabled, current.CGOEnabled))
+	}
+	if m.GOExperiment != "" && m.GOExperiment != current.GOExperiment {
+		// A lock without GOEXPERIMENT may predate its recording, so
+		// only a recorded setting is compared.
+		diffs = append(diffs, fmt.Sprintf("lock was generated with GOEXPERIMENT=%s but analysis is using GOEXPERIMENT=%s", m.GOExperiment, current.GOExperiment))
+	}
+	if m.Aggregate && !current.Aggregate {
+		// A complete lock can be compared in aggregate, but an
+		// aggregate lock cannot be compared by package.
+		diffs = append(diffs, "lock holds aggregate capabilities but analysis is not using -aggregate-lock")
+	}
+	if m.Tags != current.Tags {
+		diffs = append(diffs, fmt.Sprintf("lock was generated with build tags %q but analysis is using %q", m.Tags, current.Tags))
+	}
+	return append(diffs, m.mapMismatches(current)...)
+}
+
+// mapMismatches returns descriptions of differences between the capability
+// map recorded in m, the metadata of a lock, and the capability map of the
+// current analysis. A nil m has no mismatches.
+func (m *metadata) mapMismatches(current metadata) []string {
+	if m == nil {
+		return nil
+	}
+	var diffs []string
+	switch {
+	case m.CapabilityMap == current.CapabilityMap:
+	case m.CapabilityMap == "":
+		diffs = append(diffs, fmt.Sprintf("lock was generated without a custom capability map but analysis is using one with hash %s", current.CapabilityMap))
+	case current.CapabilityMap == "":
+		diffs = append(diffs, fmt.Sprintf("lock was generated with a custom capability map with hash %s but analysis is using none", m.CapabilityMap))
+	default:
+		diffs = append(diffs, fmt.Sprintf("lock was generated with a capability map with hash %s but analysis is using one with hash %s", m.CapabilityMap, current.CapabilityMap))
+	}
+	if m.DisableBuiltin != current.DisableBuiltin {
+		diffs = append(diffs, fmt.Sprintf("lock was generated with disable_builtin=%t but analysis is using disable_builtin=%t", m.DisableBuiltin, current.DisableBuiltin))
+	}
+	return diffs
+}
+
+// metaPath returns the path of the metadata file for the lock file at path.
+func metaPath(lock string) string {
+	return strings.TrimSuffix(lock, filepath.Ext(lock)) + ".meta"
+}
+
+// readMeta returns the metadata at path. If no file exists at path, a nil
+// metadata and error are returned.
+func readMeta(path string) (*metadata, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m metadata
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// writeMeta writes m to path.
+func writeMeta(path string, m metadata) error {
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, append(b, '\n'), 0o664)
+}
+
+// writeLockMeta records the hash of the lock at path in m and writes m
+// as the metadata of the lock.
+func writeLockMeta(path string, m *metadata) error {
+	var err error
+	m.LockHash, err = fileHash(path)
+	if err != nil {
+		return err
+	}
+	return writeMeta(metaPath(path), *m)
+}
+
+// goEnv returns the values of the named go environment variables when
+// the go tool is run with the environment env.
+func goEnv(env []string, names ...string) ([]string, error) {
+	cmd := execabs.Command("go", append([]string{"env"}, names...)...)
+	cmd.Env = env
+	var buf, errBuf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &errBuf
+	err := run(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("go env %w: %v", err, &errBuf)
+	}
+	vals := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(vals) != len(names) {
+		return nil, fmt.Errorf("go env: unexpected output: %q", &buf)
+	}
+	return vals, nil
+}
+
+// majorMinor returns the major and minor components of a Go version. The
+// version may be a toolchain version such as "go1.21.3" or "go1.22rc1", or
+// a bare language version such as "1.21".
+func majorMinor(v string) (major, minor int, ok bool) {
+	v = strings.TrimPrefix(v, "devel ")
+	v = strings.TrimPrefix(v, "go")
+	maj, rest, ok := strings.Cut(v, ".")
+	if !ok {
+		return 0, 0, false
+	}
+	end := strings.IndexFunc(rest, func(r rune) bool { return r < '0' || '9' < r })
+	if end >= 0 {
+		rest = rest[:end]
+	}
+	major, err := strconv.Atoi(maj)
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err = strconv.Atoi(rest)
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
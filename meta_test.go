@@ -0,0 +1,24 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMismatchesGOExperiment(t *testing.T) {
+	for _, test := range []struct {
+		lock, current string
+		want          []string
+	}{
+		{lock: "", current: "", want: nil},
+		{lock: "", current: "arenas", want: nil},
+		{lock: "arenas", current: "arenas", want: nil},
+		{lock: "arenas", current: "", want: []string{"lock was generated with GOEXPERIMENT=arenas but analysis is using GOEXPERIMENT="}},
+	} {
+		m := &metadata{GOExperiment: test.lock}
+		got := m.mismatches(metadata{GOExperiment: test.current})
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected mismatches for lock %q and current %q: got:%q want:%q", test.lock, test.current, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// writeMetrics writes gauges describing a check of the current report
+// with the given coverage and changes to the file at path in the
+// Prometheus text exposition format.
+func writeMetrics(path string, current *capslockReport, cov coverage, changes []change) error {
+	counts := make(map[string]int)
+	for _, caps := range capabilities(current) {
+		for capability := range caps {
+			counts[strings.TrimPrefix(capability, "CAPABILITY_")]++
+		}
+	}
+	names := make([]string, 0, len(counts))
+	for capability := range counts {
+		names = append(names, capability)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	gauge(&buf, "cl_packages_analyzed", "Number of imports analysed.")
+	fmt.Fprintf(&buf, "cl_packages_analyzed %d\n", cov.analysed)
+	gauge(&buf, "cl_packages_errored", "Number of imports that could not be analysed.")
+	fmt.Fprintf(&buf, "cl_packages_errored %d\n", len(cov.missing))
+	gauge(&buf, "cl_capabilities_total", "Number of packages with each capability, without the CAPABILITY_ prefix.")
+	for _, capability := range names {
+		fmt.Fprintf(&buf, "cl_capabilities_total{capability=%q} %d\n", capability, counts[capability])
+	}
+	gauge(&buf, "cl_changes_detected", "Number of packages with capability changes.")
+	fmt.Fprintf(&buf, "cl_changes_detected %d\n", len(changes))
+	return writeFile(path, buf.Bytes(), 0o664)
+}
+
+// gauge writes the HELP and TYPE lines of the named gauge to buf.
+func gauge(buf *bytes.Buffer, name, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/execabs"
+	"golang.org/x/tools/go/packages"
+)
+
+// goModule is a module in the build list as reported by go list -m -json.
+type goModule struct {
+	Path    string
+	Version string
+	Replace *goModule
+	Main    bool
+}
+
+// buildList returns the modules in the build list of the module in dir
+// when listed with the environment env.
+func buildList(dir string, env []string) ([]goModule, error) {
+	cmd := execabs.Command("go", "list", "-m", "-json", "all")
+	cmd.Dir = dir
+	cmd.Env = env
+	var buf, errBuf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &errBuf
+	err := run(cmd)
+	if err != nil {
+		return nil, authHint(fmt.Errorf("go list -m %w: %s", err, &errBuf))
+	}
+	var mods []goModule
+	dec := json.NewDecoder(&buf)
+	for {
+		var m goModule
+		err := dec.Decode(&m)
+		if errors.Is(err, io.EOF) {
+			return mods, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("go list -m: %w", err)
+		}
+		mods = append(mods, m)
+	}
+}
+
+// replacement is a replaced module in the build list.
+type replacement struct {
+	Path           string `json:"path"`
+	Version        string `json:"version,omitempty"`
+	Replace        string `json:"replace"` // module path or local directory
+	ReplaceVersion string `json:"replaceVersion,omitempty"`
+}
+
+// mainModule returns the path of the main module in mods, or "." if there
+// is none.
+func mainModule(mods []goModule) string {
+	for _, m := range mods {
+		if m.Main {
+			return m.Path
+		}
+	}
+	return "."
+}
+
+// replacements returns the replaced modules in mods.
+func replacements(mods []goModule) []replacement {
+	var r []replacement
+	for _, m := range mods {
+		if m.Replace == nil {
+			continue
+		}
+		r = append(r, replacement{
+			Path:           m.Path,
+			Version:        m.Version,
+			Replace:        m.Replace.Path,
+			ReplaceVersion: m.Replace.Version,
+		})
+	}
+	return r
+}
+
+// canonicalizePaths rewrites package and module paths in r that belong to
+// a module replacement in mods back to the path of the replaced module, so
+// that switching between a module and a fork of it does not change the keys
+// of the lock. Replacements with local directories, and replacement paths
+// that are also in the build list in their own right, are not rewritten.
+func canonicalizePaths(r *capslockReport, mods []goModule) {
+	inList := make(map[string]bool)
+	for _, m := range mods {
+		inList[m.Path] = true
+	}
+	forks := make(map[string]string)
+	for _, m := range mods {
+		if m.Replace == nil || m.Replace.Version == "" || m.Replace.Path == m.Path || inList[m.Replace.Path] {
+			continue
+		}
+		forks[m.Replace.Path] = m.Path
+	}
+	if len(forks) == 0 {
+		return
+	}
+	rewrite := func(s string) string {
+		for fork, orig := range forks {
+			if s == fork || strings.HasPrefix(s, fork+"/") {
+				return orig + s[len(fork):]
+			}
+		}
+		return s
+	}
+	rewriteName := func(s string) string {
+		for fork, orig := range forks {
+			s = strings.ReplaceAll(s, fork+".", orig+".")
+			s = strings.ReplaceAll(s, fork+"/", orig+"/")
+		}
+		return s
+	}
+	for i := range r.CapabilityInfo {
+		ci := &r.CapabilityInfo[i]
+		ci.PackageDir = rewrite(ci.PackageDir)
+		ci.DepPath = rewriteName(ci.DepPath)
+		for j := range ci.Path {
+			fn := &ci.Path[j]
+			fn.Package = rewrite(fn.Package)
+			fn.Name = rewriteName(fn.Name)
+		}
+	}
+	for i := range r.ModuleInfo {
+		r.ModuleInfo[i].Path = rewrite(r.ModuleInfo[i].Path)
+	}
+	for i := range r.PackageInfo {
+		r.PackageInfo[i].Path = rewrite(r.PackageInfo[i].Path)
+	}
+}
+
+// markLocalReplacements records in the module information of r the local
+// directory of each module that is replaced by one in mods, since its
+// capabilities were found in code that may not exist on other machines.
+func markLocalReplacements(r *capslockReport, mods []goModule) {
+	local := make(map[string]string)
+	for _, m := range mods {
+		if m.Replace != nil && m.Replace.Version == "" {
+			local[m.Path] = m.Replace.Path
+		}
+	}
+	for i := range r.ModuleInfo {
+		if dir, ok := local[r.ModuleInfo[i].Path]; ok {
+			r.ModuleInfo[i].Local = dir
+		}
+	}
+}
+
+// conflictingModules returns the module paths that are provided at more
+// than one version among pkgs and their loaded imports, mapped to the sorted
+// versions. A replaced module is described by its version and replacement,
+// as in "v1.2.0 => ../fork". Since the go command selects a single version
+// of each module, a conflict indicates a broken module graph, and capability
+// attribution to the module is ambiguous.
+func conflictingModules(pkgs []*packages.Package) map[string][]string {
+	versions := make(map[string]map[string]bool)
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		m := pkg.Module
+		if m == nil {
+			return
+		}
+		v := m.Version
+		if r := m.Replace; r != nil {
+			v = strings.TrimSpace(v + " => " + strings.TrimSpace(r.Path+" "+r.Version))
+		}
+		if versions[m.Path] == nil {
+			versions[m.Path] = make(map[string]bool)
+		}
+		versions[m.Path][v] = true
+	})
+	conflicts := make(map[string][]string)
+	for path, vs := range versions {
+		if len(vs) < 2 {
+			continue
+		}
+		for v := range vs {
+			conflicts[path] = append(conflicts[path], v)
+		}
+		sort.Strings(conflicts[path])
+	}
+	return conflicts
+}
+
+// warnConflicts writes a warning to w for each module that is provided at
+// more than one version among pkgs and their loaded imports.
+func warnConflicts(w io.Writer, pkgs []*packages.Package) {
+	conflicts := conflictingModules(pkgs)
+	if len(conflicts) == 0 {
+		return
+	}
+	paths := make([]string, 0, len(conflicts))
+	for path := range conflicts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(w, "warning: module %s is loaded at multiple versions: %s\n", path, strings.Join(conflicts[path], ", "))
+	}
+	fmt.Fprintln(w, "warning: capabilities may be attributed to the wrong module version; fix the module graph before trusting the lock")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+func TestWarnConflicts(t *testing.T) {
+	dep := &packages.Module{Path: "example.com/dep", Version: "v1.0.0"}
+	fork := &packages.Module{Path: "example.com/dep", Version: "v1.0.0", Replace: &packages.Module{Path: "../dep"}}
+	app := &packages.Package{
+		ID:     "example.com/app",
+		Module: &packages.Module{Path: "example.com/app", Main: true},
+		Imports: map[string]*packages.Package{
+			"example.com/dep":   {ID: "example.com/dep", Module: dep},
+			"example.com/dep/b": {ID: "example.com/dep/b", Module: fork},
+			"os":                {ID: "os"},
+		},
+	}
+
+	var buf bytes.Buffer
+	warnConflicts(&buf, []*packages.Package{app})
+	want := "warning: module example.com/dep is loaded at multiple versions: v1.0.0, v1.0.0 => ../dep\n"
+	if !strings.HasPrefix(buf.String(), want) {
+		t.Errorf("unexpected warning: got:%q want prefix:%q", buf.String(), want)
+	}
+
+	buf.Reset()
+	delete(app.Imports, "example.com/dep/b")
+	warnConflicts(&buf, []*packages.Package{app})
+	if buf.Len() != 0 {
+		t.Errorf("unexpected warning for consistent modules: %q", buf.String())
+	}
+}
+
+func TestWarnConflictsFixture(t *testing.T) {
+	app := copyFixture(t)
+	load := func(dir string) []*packages.Package {
+		var cfg config
+		loadCfg := cfg.loadConfig()
+		loadCfg.Mode |= packages.NeedDeps
+		loadCfg.Dir = dir
+		pkgs, err := loadPackages(loadCfg, "./...")
+		if err != nil {
+			t.Fatalf("unexpected error loading %s: %v", dir, err)
+		}
+		return pkgs
+	}
+	// The app replaces example.com/app-dep with the local directory, so
+	// its packages are provided by the replacement, while loaded in its
+	// own module they are provided by the main module.
+	pkgs := load(app)
+	var buf bytes.Buffer
+	warnConflicts(&buf, pkgs)
+	if buf.Len() != 0 {
+		t.Errorf("unexpected warning for the app: %q", buf.String())
+	}
+	pkgs = append(pkgs, load(filepath.Join(filepath.Dir(app), "dep"))...)
+	warnConflicts(&buf, pkgs)
+	want := "warning: module example.com/app-dep is loaded at multiple versions: "
+	if !strings.HasPrefix(buf.String(), want) || !strings.Contains(buf.String(), "=> ../dep") {
+		t.Errorf("unexpected warning: got:%q want prefix:%q", buf.String(), want)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// osvRecord is an advisory-like record of a high severity capability added
+// to a package, in the shape of an OSV entry. It is not a real OSV entry:
+// the ID is derived from the change and is not registered with any database.
+type osvRecord struct {
+	SchemaVersion    string              `json:"schema_version"`
+	ID               string              `json:"id"`
+	Modified         string              `json:"modified"`
+	Summary          string              `json:"summary"`
+	Details          string              `json:"details"`
+	Affected         []osvAffected       `json:"affected"`
+	DatabaseSpecific osvDatabaseSpecific `json:"database_specific"`
+}
+
+// osvAffected is the module and package affected by an added capability.
+type osvAffected struct {
+	Package           osvPackage `json:"package"`
+	Versions          []string   `json:"versions,omitempty"`
+	EcosystemSpecific osvImports `json:"ecosystem_specific"`
+}
+
+type osvPackage struct {
+	Ecosystem string `json:"ecosystem"`
+	Name      string `json:"name"`
+}
+
+// osvImports lists the affected packages of a module, as in the Go
+// vulnerability database.
+type osvImports struct {
+	Imports []osvImport `json:"imports"`
+}
+
+type osvImport struct {
+	Path string `json:"path"`
+}
+
+// osvDatabaseSpecific holds the added capability and its severity.
+type osvDatabaseSpecific struct {
+	Capability string `json:"capability"`
+	Severity   string `json:"severity"`
+}
+
+// writeOSV writes the high severity capabilities added in changes to w as
+// a JSON array of OSV-style advisory records, modified at now. Removed and
+// lower severity capabilities are not reported.
+func writeOSV(w io.Writer, changes []change, now time.Time) error {
+	records := []osvRecord{}
+	for _, c := range changes {
+		name := c.module
+		if name == "" {
+			name = c.Package
+		}
+		var versions []string
+		if c.moduleVersion != "" {
+			versions = []string{c.moduleVersion}
+		}
+		for _, capability := range c.Added {
+			if capabilitySeverity(capability) != high {
+				continue
+			}
+			var details bytes.Buffer
+			fmt.Fprintf(&details, "Package %s has new capability %s compared to the baseline%s.\n", c.Package, capability, c.version())
+			writeCallPath(&details, c.paths[capability])
+			sum := sha256.Sum256([]byte(name + "@" + c.moduleVersion + " " + c.Package + " " + capability))
+			records = append(records, osvRecord{
+				SchemaVersion: "1.6.0",
+				ID:            "CL-" + hex.EncodeToString(sum[:8]),
+				Modified:      now.UTC().Format(time.RFC3339),
+				Summary:       fmt.Sprintf("%s gained capability %s", c.Package, capability),
+				Details:       details.String(),
+				Affected: []osvAffected{{
+					Package:           osvPackage{Ecosystem: "Go", Name: name},
+					Versions:          versions,
+					EcosystemSpecific: osvImports{Imports: []osvImport{{Path: c.Package}}},
+				}},
+				DatabaseSpecific: osvDatabaseSpecific{Capability: capability, Severity: high.String()},
+			})
+		}
+	}
+	b, err := json.MarshalIndent(records, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
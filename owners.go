@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// reportFormats are the valid output formats for capability reports.
+var reportFormats = map[string]bool{"markdown": true, "json": true}
+
+// capabilityOwners is the set of analysed dependencies that have a
+// capability.
+type capabilityOwners struct {
+	Capability   string            `json:"capability"`
+	Severity     string            `json:"severity"`
+	Dependencies []ownedDependency `json:"dependencies"`
+}
+
+// ownedDependency is a dependency with a capability and the first-party
+// packages that reach it.
+type ownedDependency struct {
+	Package   string   `json:"package"`
+	Module    string   `json:"module,omitempty"`
+	Version   string   `json:"version,omitempty"`
+	Direct    bool     `json:"direct"`
+	ReachedBy []string `json:"reachedBy"`
+}
+
+// firstPartyReach returns the first-party packages in pkgs whose
+// transitive imports include each package, keyed by package import path.
+// Test variants are reported under the path of the package they test.
+// The pkgs must have been loaded with packages.NeedDeps.
+func firstPartyReach(pkgs []*packages.Package) map[string][]string {
+	reach := make(map[string][]string)
+	for _, pkg := range pkgs {
+		if strings.HasSuffix(pkg.ID, ".test") {
+			// Skip synthesized test main packages.
+			continue
+		}
+		seen := make(map[*packages.Package]bool)
+		var walk func(*packages.Package)
+		walk = func(p *packages.Package) {
+			for _, dep := range p.Imports {
+				if seen[dep] {
+					continue
+				}
+				seen[dep] = true
+				reach[dep.PkgPath] = append(reach[dep.PkgPath], pkg.PkgPath)
+				walk(dep)
+			}
+		}
+		walk(pkg)
+	}
+	for p, by := range reach {
+		reach[p] = dedup(by)
+	}
+	return reach
+}
+
+// owners returns the packages in r that have each capability, with the
+// first-party packages in reach that import them, ordered by capability
+// and then by package.
+func owners(r *capslockReport, reach map[string][]string) []capabilityOwners {
+	versions := moduleVersions(r)
+	byCap := make(map[string][]ownedDependency)
+	for pkg, caps := range capabilities(r) {
+		mod := moduleFor(pkg, versions)
+		for capability, ci := range caps {
+			byCap[capability] = append(byCap[capability], ownedDependency{
+				Package:   pkg,
+				Module:    mod,
+				Version:   versions[mod],
+				Direct:    ci.CapabilityType == direct,
+				ReachedBy: reach[pkg],
+			})
+		}
+	}
+	names := make([]string, 0, len(byCap))
+	for capability := range byCap {
+		names = append(names, capability)
+	}
+	sort.Strings(names)
+	o := make([]capabilityOwners, 0, len(names))
+	for _, capability := range names {
+		deps := byCap[capability]
+		sort.Slice(deps, func(i, j int) bool { return deps[i].Package < deps[j].Package })
+		o = append(o, capabilityOwners{Capability: capability, Severity: capabilitySeverity(capability).String(), Dependencies: deps})
+	}
+	return o
+}
+
+// writeOwners writes the capability owners in o to w in the given format,
+// either as a markdown document with a table for each capability or as
+// JSON.
+func writeOwners(w io.Writer, format string, o []capabilityOwners) error {
+	if format == "json" {
+		b, err := json.MarshalIndent(o, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+	_, err := fmt.Fprintln(w, "# Capabilities by dependency")
+	if err != nil {
+		return err
+	}
+	if len(o) == 0 {
+		_, err = fmt.Fprintln(w, "\nNo analysed dependency has any capability.")
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range o {
+		_, err = fmt.Fprintf(w, "\n## %s (%s)\n\n| Dependency | Module | Version | Reached by |\n| --- | --- | --- | --- |\n", c.Capability, c.Severity)
+		if err != nil {
+			return err
+		}
+		for _, d := range c.Dependencies {
+			by := make([]string, len(d.ReachedBy))
+			for i, p := range d.ReachedBy {
+				by[i] = "`" + p + "`"
+			}
+			name := "`" + d.Package + "`"
+			if !d.Direct {
+				name += " (transitive)"
+			}
+			mod := d.Module
+			if mod != "" {
+				mod = "`" + mod + "`"
+			}
+			_, err = fmt.Fprintf(w, "| %s | %s | %s | %s |\n", name, mod, d.Version, strings.Join(by, ", "))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/execabs"
+	"golang.org/x/tools/go/packages"
+)
+
+// preview prints the capability changes in the packages of a module that
+// are imported by the current module that would result from resolving the
+// module at the version in query, given as path@version. The go.mod and
+// go.sum files of the current module are not changed; the version is
+// resolved in temporary copies that are used for the analysis.
+func preview(cfg config, query string) int {
+	path, version, ok := strings.Cut(query, "@")
+	if !ok || path == "" || version == "" {
+		fmt.Fprintf(os.Stderr, "invalid module query: %q\n", query)
+		return invocationError
+	}
+	root, valid, err := moduleRoot()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if valid {
+			return invocationError
+		}
+		return internalError
+	}
+
+	loadCfg := &packages.Config{
+		Tests:      cfg.tests,
+		Mode:       packages.NeedName | packages.NeedImports | packages.NeedDeps | packages.NeedModule,
+		Env:        cfg.environ(),
+		BuildFlags: cfg.buildFlags(),
+		Dir:        root,
+	}
+	pkgs, err := loadPackages(loadCfg, filepath.Join(root, "..."))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errorStatus(err)
+	}
+	mods := make(map[string]*packages.Module)
+	for _, pkg := range pkgs {
+		for imp, dep := range pkg.Imports {
+			if dep.Module != nil && dep.Module.Path == path {
+				mods[imp] = dep.Module
+			}
+		}
+	}
+	if len(mods) == 0 {
+		fmt.Fprintf(os.Stderr, "no packages in %s are imported\n", path)
+		return invocationError
+	}
+	imports := make([]string, 0, len(mods))
+	for imp := range mods {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+
+	tmp, err := os.MkdirTemp("", "cl-preview-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return internalError
+	}
+	defer os.RemoveAll(tmp)
+	modfile, err := resolveModule(cfg, root, tmp, query)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return internalError
+	}
+
+	current, err := capslockJSON(cfg, imports)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errorStatus(err)
+	}
+	flags, _ := lookupEnv(cfg.environ(), "GOFLAGS")
+	flags += " -modfile=" + modfile
+	if !strings.Contains(flags, "-mod=") {
+		// Some go commands run by capslock reject -modfile unless
+		// -mod is also set.
+		flags += " -mod=readonly"
+	}
+	pcfg := cfg
+	pcfg.env = append(cfg.env[:len(cfg.env):len(cfg.env)], "GOFLAGS="+strings.TrimSpace(flags))
+	proposed, err := capslockJSON(pcfg, imports)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errorStatus(err)
+	}
+	changes := cfg.compare(current, proposed, mods)
+	err = writeChanges(os.Stdout, cfg.format, changes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errorStatus(err)
+	}
+	if len(changes) != 0 {
+		return capChangeError
+	}
+	return success
+}
+
+// resolveModule copies the go.mod and go.sum files of the module at root
+// into dir and resolves the module query in the copies with go get. It
+// returns the path of the go.mod copy, which may be used with the go
+// command's -modfile flag.
+func resolveModule(cfg config, root, dir, query string) (string, error) {
+	modfile := filepath.Join(dir, "go.mod")
+	for _, name := range []string{"go.mod", "go.sum"} {
+		b, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			if name == "go.sum" && errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return "", err
+		}
+		err = os.WriteFile(filepath.Join(dir, name), b, 0o664)
+		if err != nil {
+			return "", err
+		}
+	}
+	cmd := execabs.Command("go", "get", "-modfile="+modfile, query)
+	cmd.Dir = root
+	cmd.Env = cfg.environ()
+	var errBuf bytes.Buffer
+	cmd.Stderr = &errBuf
+	err := run(cmd)
+	if err != nil {
+		return "", authHint(fmt.Errorf("go get %w: %v", err, &errBuf))
+	}
+	return modfile, nil
+}
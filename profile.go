@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/execabs"
+)
+
+// startProfiling starts CPU profiling to cpu and subprocess tracing to trace
+// if they are not empty. The returned function must be called to stop
+// profiling and to write a heap profile to mem if it is not empty.
+func startProfiling(cpu, mem, trace string) (stop func() error, err error) {
+	var closers []func() error
+	stop = func() error {
+		var err error
+		for i := len(closers) - 1; i >= 0; i-- {
+			if e := closers[i](); e != nil && err == nil {
+				err = e
+			}
+		}
+		return err
+	}
+	if cpu != "" {
+		f, err := os.Create(cpu)
+		if err != nil {
+			return nil, err
+		}
+		err = pprof.StartCPUProfile(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		closers = append(closers, func() error {
+			pprof.StopCPUProfile()
+			return f.Close()
+		})
+	}
+	if trace != "" {
+		f, err := os.Create(trace)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		tracer = &subprocTracer{w: f}
+		closers = append(closers, func() error {
+			tracer = nil
+			return f.Close()
+		})
+	}
+	if mem != "" {
+		closers = append(closers, func() error {
+			f, err := os.Create(mem)
+			if err != nil {
+				return err
+			}
+			runtime.GC()
+			err = pprof.WriteHeapProfile(f)
+			if err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		})
+	}
+	return stop, nil
+}
+
+// tracer records subprocess start and end times when it is not nil.
+var tracer *subprocTracer
+
+// subprocTracer writes a tab-separated line for each traced span holding
+// the start and end times, the duration, the exit status and the command.
+type subprocTracer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (t *subprocTracer) record(name string, start, end time.Time, err error) {
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "%s\t%s\t%s\t%s\t%s\n",
+		start.Format(time.RFC3339Nano), end.Format(time.RFC3339Nano), end.Sub(start), status, name)
+}
+
+// span runs fn, recording its timing under name if tracing is enabled.
+func span(name string, fn func() error) error {
+	if tracer == nil {
+		return fn()
+	}
+	start := time.Now()
+	err := fn()
+	tracer.record(name, start, time.Now(), err)
+	return err
+}
+
+// run runs cmd, recording its timing if tracing is enabled.
+func run(cmd *execabs.Cmd) error {
+	return span(strings.Join(cmd.Args, " "), cmd.Run)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/execabs"
+	"golang.org/x/tools/go/packages"
+)
+
+// remote prints the capabilities of the packages of a module given by the
+// module query, path@version, without reference to the current module.
+// The module is resolved in an ephemeral module in a temporary directory
+// which is removed on return. If no version is given, the latest version
+// is used.
+func remote(cfg config, query string) int {
+	path, _, ok := strings.Cut(query, "@")
+	if !ok {
+		query += "@latest"
+	}
+	if path == "" {
+		fmt.Fprintf(os.Stderr, "invalid module query: %q\n", query)
+		return invocationError
+	}
+	tmp, err := os.MkdirTemp("", "cl-remote-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return internalError
+	}
+	defer os.RemoveAll(tmp)
+	// The go tool and capslock are run in the ephemeral module.
+	cfg.dir = tmp
+
+	for _, args := range [][]string{
+		{"mod", "init", "cl.remote/analysis"},
+		{"get", query},
+	} {
+		cmd := execabs.Command("go", args...)
+		cmd.Dir = tmp
+		cmd.Env = cfg.environ()
+		var errBuf bytes.Buffer
+		cmd.Stderr = &errBuf
+		err = run(cmd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not resolve %s: go %s: %v\n%s", query, args[0], err, &errBuf)
+			if authFailure.Match(errBuf.Bytes()) {
+				fmt.Fprintln(os.Stderr, authAdvice)
+				return invocationError
+			}
+			if args[0] == "get" {
+				fmt.Fprintln(os.Stderr, "check the module path and version, and that GOPROXY and any GOPRIVATE or GONOSUMDB settings allow it to be fetched")
+				return invocationError
+			}
+			return internalError
+		}
+	}
+
+	loadCfg := &packages.Config{
+		Mode:       packages.NeedName | packages.NeedModule,
+		Env:        cfg.environ(),
+		BuildFlags: cfg.buildFlags(),
+		Dir:        tmp,
+	}
+	pkgs, err := loadPackages(loadCfg, path+"/...")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errorStatus(err)
+	}
+	var (
+		mod   *packages.Module
+		paths []string
+	)
+	for _, pkg := range pkgs {
+		if pkg.Module == nil || pkg.Module.Path != path {
+			// Skip packages in nested modules.
+			continue
+		}
+		mod = pkg.Module
+		if cfg.includeInternal || !isInternal(strings.TrimPrefix(pkg.PkgPath, path)) {
+			paths = append(paths, pkg.PkgPath)
+		}
+	}
+	if len(paths) == 0 {
+		fmt.Fprintf(os.Stderr, "no packages found in %s\n", query)
+		return invocationError
+	}
+	fmt.Printf("%s@%s: %d packages\n", mod.Path, mod.Version, len(paths))
+	format := "" // Use capslock's default summary.
+	if cfg.verbose {
+		format = "verbose"
+	}
+	buf, err := capslock(cfg, dedup(paths), format, "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errorStatus(err)
+	}
+	fmt.Print(buf)
+	return success
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestRemoteDir(t *testing.T) {
+	// The module is a dependency of cl, so it is in the module cache.
+	t.Setenv("GOPROXY", "off")
+	t.Setenv("GONOSUMDB", "golang.org/x/mod")
+	t.Setenv("GOFLAGS", "-mod=mod")
+	var dir string
+	orig := runCapslock
+	runCapslock = func(d string, args, env []string) (*bytes.Buffer, *bytes.Buffer, error) {
+		dir = d
+		_, err := os.Stat(filepath.Join(d, "go.mod"))
+		if err != nil {
+			t.Errorf("capslock not run in the ephemeral module: %v", err)
+		}
+		return bytes.NewBufferString("summary\n"), new(bytes.Buffer), nil
+	}
+	t.Cleanup(func() { runCapslock = orig })
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	status := remote(config{goos: runtime.GOOS, goarch: runtime.GOARCH}, "golang.org/x/mod@v0.13.0")
+	if status == invocationError {
+		t.Skip("module not available without network access")
+	}
+	if status != success {
+		t.Fatalf("unexpected exit status: %d", status)
+	}
+	if dir == "" || dir == wd {
+		t.Errorf("unexpected capslock directory: %q", dir)
+	}
+	got, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != wd {
+		t.Errorf("working directory changed: got:%s want:%s", got, wd)
+	}
+	_, err = os.Stat(dir)
+	if !os.IsNotExist(err) {
+		t.Errorf("ephemeral module not removed: %v", err)
+	}
+}
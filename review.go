@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// readReviewed returns the acknowledged capability removals recorded in the
+// marker file at path. Each line of the file holds a package path and a
+// capability separated by whitespace. Blank lines and lines starting with
+// '#' are ignored. If no file exists at path, no removals are acknowledged.
+func readReviewed(path string) (map[capKey]bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reviewed := make(map[capKey]bool)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: invalid reviewed removal: %q", path, n, line)
+		}
+		reviewed[capKey{fields[0], fields[1]}] = true
+	}
+	return reviewed, sc.Err()
+}
+
+// splitRemovals returns changes with capability removals taken out,
+// dropping changes that have nothing else, and the removed capabilities
+// by package that are not in reviewed.
+func splitRemovals(changes []change, reviewed map[capKey]bool) (kept []change, removals []capKey) {
+	for _, c := range changes {
+		for _, capability := range c.Removed {
+			if !reviewed[capKey{c.Package, capability}] {
+				removals = append(removals, capKey{c.Package, capability})
+			}
+		}
+		c.Removed = nil
+		if len(c.Added) != 0 || len(c.Direct) != 0 {
+			kept = append(kept, c)
+		}
+	}
+	return kept, removals
+}
+
+// writeRemovals writes the capability removals needing acknowledgment to w,
+// preceded by a blank line if sep is true.
+func writeRemovals(w io.Writer, marker string, removals []capKey, sep bool) error {
+	if len(removals) == 0 {
+		return nil
+	}
+	if sep {
+		_, err := fmt.Fprintln(w)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "Capability removals needing acknowledgment (record in %s):\n", marker)
+	if err != nil {
+		return err
+	}
+	for _, r := range removals {
+		_, err = fmt.Fprintf(w, "\t%s %s\n", r.pkg, r.capability)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
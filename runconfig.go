@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/execabs"
+)
+
+// runConfig is the effective configuration of an analysis, written with
+// -write-config so that the conditions a lock was generated or checked
+// under can be audited.
+type runConfig struct {
+	GOOS       string `json:"goos"`
+	GOARCH     string `json:"goarch"`
+	CGOEnabled string `json:"cgoEnabled"`
+	Experiment string `json:"goExperiment,omitempty"`
+	Tags       string `json:"tags,omitempty"`
+
+	GoVersion       string `json:"goVersion"`
+	Toolchain       string `json:"toolchain,omitempty"`
+	CapslockVersion string `json:"capslockVersion,omitempty"`
+
+	Stdlib bool `json:"stdlib"`
+	Tests  bool `json:"tests"`
+
+	Ignore        []string `json:"ignore,omitempty"`
+	IgnoreModules []string `json:"ignoreModules,omitempty"`
+	IgnoreChanges []string `json:"ignoreChanges,omitempty"`
+	CapslockArgs  []string `json:"capslockArgs,omitempty"`
+
+	CapabilityMap     string `json:"capabilityMap,omitempty"`
+	CapabilityMapHash string `json:"capabilityMapHash,omitempty"`
+	DisableBuiltin    bool   `json:"disableBuiltin,omitempty"`
+}
+
+// effectiveConfig returns the effective configuration of analysis with cfg
+// in the environment described by meta.
+func effectiveConfig(cfg config, meta metadata) (runConfig, error) {
+	rc := runConfig{
+		GOOS:         cfg.goos,
+		GOARCH:       cfg.goarch,
+		CGOEnabled:   meta.CGOEnabled,
+		Experiment:   meta.GOExperiment,
+		Tags:         cfg.tags,
+		GoVersion:    meta.GoVersion,
+		Toolchain:    meta.Toolchain,
+		Stdlib:       cfg.stdlib,
+		Tests:        cfg.tests,
+		CapslockArgs: cfg.extra,
+
+		CapabilityMap:  cfg.custom,
+		DisableBuiltin: cfg.noBuiltin,
+	}
+	for _, m := range cfg.ignore {
+		rc.Ignore = append(rc.Ignore, m.re.String())
+	}
+	for _, m := range cfg.ignoreMod {
+		rc.IgnoreModules = append(rc.IgnoreModules, m.re.String())
+	}
+	for _, ig := range cfg.ignoreChg {
+		rc.IgnoreChanges = append(rc.IgnoreChanges, ig.pkg.re.String()+":"+ig.capability)
+	}
+	var err error
+	rc.CapslockVersion, err = capslockVersion(cfg.environ())
+	if err != nil {
+		return runConfig{}, err
+	}
+	if cfg.custom != "" {
+		rc.CapabilityMapHash, err = fileHash(cfg.customMap)
+		if err != nil {
+			return runConfig{}, err
+		}
+	}
+	return rc, nil
+}
+
+// writeRunConfig writes rc to path.
+func writeRunConfig(path string, rc runConfig) error {
+	b, err := json.MarshalIndent(rc, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, append(b, '\n'), 0o664)
+}
+
+// capslockVersion returns the module version of the capslock executable
+// in the path, as reported by go version -m. The empty string is returned
+// if the executable has no module version information.
+func capslockVersion(env []string) (string, error) {
+	path, err := execabs.LookPath("capslock")
+	if err != nil {
+		return "", err
+	}
+	cmd := execabs.Command("go", "version", "-m", path)
+	cmd.Env = env
+	var buf, errBuf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &errBuf
+	err = run(cmd)
+	if err != nil {
+		return "", fmt.Errorf("go version %w: %v", err, &errBuf)
+	}
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) >= 3 && f[0] == "mod" {
+			return f[2], nil
+		}
+	}
+	return "", nil
+}
+
+// fileHash returns the hex encoded SHA-256 hash of the file at path.
+func fileHash(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// runSummary is a line of the JSONL run log, summarising a single check.
+type runSummary struct {
+	Time       string `json:"time"`
+	Revision   string `json:"revision,omitempty"`
+	Changes    int    `json:"changes"`
+	Result     string `json:"result"` // pass, fail or error
+	ExitStatus int    `json:"exitStatus"`
+}
+
+// appendRun completes s with the result of a check that exited with the
+// given status and appends it as a JSON line to the file at path, creating
+// the file if necessary. Existing lines are never modified.
+func appendRun(path string, s *runSummary, status int) error {
+	s.ExitStatus = status
+	switch {
+	case status == success:
+		s.Result = "pass"
+	case status&^(capChangeError|budgetError) == 0:
+		s.Result = "fail"
+	default:
+		s.Result = "error"
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o664)
+	if err != nil {
+		return err
+	}
+	// The line is written with a single write so that concurrent
+	// appends are not interleaved.
+	_, err = f.Write(append(b, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// newRunSummary returns the summary of a check of the git repository
+// containing the working directory, started at now.
+func newRunSummary(now time.Time) *runSummary {
+	return &runSummary{Time: now.UTC().Format(time.RFC3339), Revision: gitRevision("")}
+}
+
+// record records the number of changes found by the check. It does nothing
+// if s is nil, when the check is not logged.
+func (s *runSummary) record(changes int) {
+	if s != nil {
+		s.Changes = changes
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// stdlibLock is the set of standard library capability baselines written
+// with -strict-stdlib, keyed by Go major.minor version, for example "go1.21".
+// Standard library capabilities change with the toolchain, so they are
+// recorded separately from the lock of dependency capabilities.
+type stdlibLock map[string]*capslockReport
+
+// stdlibKey returns the stdlibLock key for the Go toolchain version v.
+func stdlibKey(v string) string {
+	major, minor, ok := majorMinor(v)
+	if !ok {
+		return v
+	}
+	return fmt.Sprintf("go%d.%d", major, minor)
+}
+
+// readStdlibLock returns the standard library lock at path. If no file
+// exists at path, an empty lock is returned.
+func readStdlibLock(path string) (stdlibLock, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make(stdlibLock), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	l, err := parseStdlibLock(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return l, nil
+}
+
+// parseStdlibLock parses a standard library lock with the same strictness
+// as parseReport. Each baseline must be a valid capslock report.
+func parseStdlibLock(b []byte) (stdlibLock, error) {
+	var l stdlibLock
+	err := decodeStrict(b, &l)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stdlib lock: %w", err)
+	}
+	for key, r := range l {
+		if r == nil {
+			return nil, fmt.Errorf("invalid stdlib lock: %s: missing report", key)
+		}
+		err = r.validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid stdlib lock: %s: %w", key, err)
+		}
+	}
+	if l == nil {
+		l = make(stdlibLock)
+	}
+	return l, nil
+}
+
+// writeStdlibLock writes l to the file at path in canonical form.
+func writeStdlibLock(path string, l stdlibLock) error {
+	for _, r := range l {
+		r.canonicalize()
+	}
+	b, err := json.MarshalIndent(l, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, append(b, '\n'), 0o664)
+}
+
+// checkStdlib compares the capabilities of the standard library packages
+// pkgs with the baseline for the Go version key in the standard library
+// lock at path, printing a warning describing any changes. If the lock has
+// no baseline for key, the baseline for the latest earlier version is used,
+// so that changes due to a toolchain update are reported.
+func checkStdlib(cfg config, path, key string, pkgs []string) error {
+	l, err := readStdlibLock(path)
+	if err != nil {
+		return err
+	}
+	baseline, ok := l[key]
+	if !ok {
+		prev := l.latestBefore(key)
+		if prev == "" {
+			fmt.Fprintf(os.Stderr, "warning: no stdlib capability baseline for %s in %s\n", key, path)
+			return nil
+		}
+		key, baseline = prev, l[prev]
+	}
+	current, err := capslockJSON(cfg, pkgs)
+	if err != nil {
+		return err
+	}
+	changes := diff(baseline, current, cfg.trackClassification)
+	if len(changes) == 0 {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "warning: stdlib capabilities have changed from the %s baseline:\n", key)
+	return writeChanges(os.Stderr, cfg.format, changes)
+}
+
+// latestBefore returns the latest Go version key in l that is earlier than
+// key, or the empty string if there is none.
+func (l stdlibLock) latestBefore(key string) string {
+	major, minor, _ := majorMinor(key)
+	var (
+		latest         string
+		lMajor, lMinor int
+	)
+	for k := range l {
+		kMajor, kMinor, ok := majorMinor(k)
+		if !ok || kMajor > major || (kMajor == major && kMinor >= minor) {
+			continue
+		}
+		if latest == "" || kMajor > lMajor || (kMajor == lMajor && kMinor > lMinor) {
+			latest, lMajor, lMinor = k, kMajor, kMinor
+		}
+	}
+	return latest
+}
+
+// lockStdlib records the capabilities of the standard library packages
+// pkgs as the baseline for the Go version key in the standard library
+// lock at path.
+func lockStdlib(cfg config, path, key string, pkgs []string) error {
+	l, err := readStdlibLock(path)
+	if err != nil {
+		return err
+	}
+	l[key], err = capslockJSON(cfg, pkgs)
+	if err != nil {
+		return err
+	}
+	return writeStdlibLock(path, l)
+}
+
+// dropExpectedStdlib returns changes with the changes to standard library
+// packages that are explained by expected, the standard library baseline
+// for the current toolchain, removed. An added or direct capability is
+// expected if the baseline has it, and a removed one if the baseline does
+// not. Changes that have nothing else are dropped. Packages are classified
+// with isStd, and a package that cannot be classified has its changes kept.
+// If verbose is true the removed capabilities are reported to stderr.
+func dropExpectedStdlib(changes []change, expected *capslockReport, isStd func(string) (bool, error), verbose bool) []change {
+	caps := capabilities(expected)
+	kept := changes[:0]
+	for _, c := range changes {
+		if std, err := isStd(c.Package); err != nil || !std {
+			kept = append(kept, c)
+			continue
+		}
+		want := caps[c.Package]
+		keep := func(list []string, expect func(string) bool) []string {
+			var k []string
+			for _, capability := range list {
+				if !expect(capability) {
+					k = append(k, capability)
+				} else if verbose {
+					fmt.Fprintf(os.Stderr, "ignoring change to %s in %s: expected for the toolchain\n", capability, c.Package)
+				}
+			}
+			return k
+		}
+		c.Added = keep(c.Added, func(capability string) bool { _, ok := want[capability]; return ok })
+		c.Removed = keep(c.Removed, func(capability string) bool { _, ok := want[capability]; return !ok })
+		c.Direct = keep(c.Direct, func(capability string) bool { return want[capability].CapabilityType == direct })
+		if len(c.Added) != 0 || len(c.Removed) != 0 || len(c.Direct) != 0 {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stdlibCache is an on-disk cache of standard library classifications of
+// import paths. Whether a path is in the standard library only depends on
+// the toolchain and the target platform, so the cache is keyed by GOVERSION,
+// GOOS and GOARCH and is discarded wholesale when any of them changes.
+type stdlibCache struct {
+	path  string
+	dirty bool
+
+	Key    string          `json:"key"`
+	Stdlib map[string]bool `json:"stdlib"`
+}
+
+// openStdlibCache returns the stdlib classification cache for analysis in
+// the environment env, read from the user's cache directory. A missing,
+// unreadable or stale cache is replaced by an empty one. It returns nil
+// if there is no cache directory or the toolchain is a development build,
+// whose version does not identify its standard library.
+func openStdlibCache(env []string) *stdlibCache {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil
+	}
+	v, err := goEnv(env, "GOVERSION", "GOOS", "GOARCH")
+	if err != nil || strings.HasPrefix(v[0], "devel") {
+		return nil
+	}
+	c := &stdlibCache{path: filepath.Join(dir, "cl", "stdlib.json")}
+	key := strings.Join(v, " ")
+	b, err := os.ReadFile(c.path)
+	if err == nil {
+		err = json.Unmarshal(b, c)
+	}
+	if err != nil || c.Key != key || c.Stdlib == nil {
+		c.Key = key
+		c.Stdlib = make(map[string]bool)
+	}
+	return c
+}
+
+// isStdlib returns whether p is a standard library package path, using
+// the cached classification if there is one and otherwise classifying it
+// with isStdlib in the environment env with the build flags. Successful
+// classifications are added to the cache. A nil cache classifies every
+// path.
+func (c *stdlibCache) isStdlib(p string, env []string, flags ...string) (bool, error) {
+	if c == nil {
+		return isStdlib(p, env, flags...)
+	}
+	if ok, cached := c.Stdlib[p]; cached {
+		return ok, nil
+	}
+	ok, err := isStdlib(p, env, flags...)
+	if err != nil {
+		return false, err
+	}
+	c.Stdlib[p] = ok
+	c.dirty = true
+	return ok, nil
+}
+
+// save writes the cache if it has changed. Since the cache is only an
+// optimisation, a failure to write it is reported as a warning when verbose
+// is true and is otherwise ignored.
+func (c *stdlibCache) save(verbose bool) {
+	if c == nil || !c.dirty {
+		return
+	}
+	b, err := json.Marshal(c)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(c.path), 0o755)
+	}
+	if err == nil {
+		err = writeFile(c.path, b, 0o644)
+	}
+	if err != nil && verbose {
+		fmt.Fprintf(os.Stderr, "warning: could not write stdlib classification cache: %v\n", err)
+	}
+	c.dirty = false
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// writeSummary writes the capslock summary to the file at path. If a
+// header file, path with a ".header" suffix, exists, its lines are written
+// as '#' comments before the summary so that human-maintained context is
+// retained when the summary is regenerated.
+func writeSummary(path string, summary []byte) error {
+	header, err := os.ReadFile(path + ".header")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var buf bytes.Buffer
+	sc := bufio.NewScanner(bytes.NewReader(header))
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			buf.WriteString("#\n")
+		case line[0] == '#':
+			buf.WriteString(line + "\n")
+		default:
+			buf.WriteString("# " + line + "\n")
+		}
+	}
+	if buf.Len() != 0 {
+		buf.WriteByte('\n')
+	}
+	buf.Write(summary)
+	return writeFile(path, buf.Bytes(), 0o664)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// symbols returns the exported functions of the changed packages in changes
+// that have each added capability, or capability that has become direct.
+// The functions are found by running capslock with function granularity on
+// only the changed packages.
+func symbols(cfg config, changes []change) (map[capKey][]string, error) {
+	want := make(map[capKey]bool)
+	var pkgs []string
+	for _, c := range changes {
+		if len(c.Added) == 0 && len(c.Direct) == 0 {
+			continue
+		}
+		pkgs = append(pkgs, c.Package)
+		for _, capability := range append(c.Added[:len(c.Added):len(c.Added)], c.Direct...) {
+			want[capKey{c.Package, capability}] = true
+		}
+	}
+	if len(pkgs) == 0 {
+		return nil, nil
+	}
+	cfg.extra = append(cfg.extra[:len(cfg.extra):len(cfg.extra)], "-granularity", "function")
+	r, err := capslockJSON(cfg, pkgs)
+	if err != nil {
+		return nil, err
+	}
+	syms := make(map[capKey][]string)
+	for _, ci := range r.CapabilityInfo {
+		k := capKey{ci.PackageDir, ci.Capability}
+		if !want[k] || len(ci.Path) == 0 || !isExported(ci.Path[0].Name) {
+			continue
+		}
+		syms[k] = append(syms[k], ci.Path[0].Name)
+	}
+	for k, s := range syms {
+		syms[k] = dedup(s)
+	}
+	return syms, nil
+}
+
+// isExported returns whether the function or method with the qualified
+// name is exported.
+func isExported(name string) bool {
+	name = name[strings.LastIndex(name, ".")+1:]
+	r, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(r)
+}
+
+// writeSymbols writes the exported functions in syms for each added or
+// newly direct capability in changes to w.
+func writeSymbols(w io.Writer, changes []change, syms map[capKey][]string) {
+	for _, c := range changes {
+		caps := append(c.Added[:len(c.Added):len(c.Added)], c.Direct...)
+		sort.Strings(caps)
+		for _, capability := range caps {
+			s := syms[capKey{c.Package, capability}]
+			if len(s) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "\nExported functions in %s with capability %s:\n", c.Package, capability)
+			for _, f := range s {
+				fmt.Fprintf(w, "\t%s\n", f)
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// templateData is the data that -template templates are executed with.
+type templateData struct {
+	// Changes is the list of capability changes sorted by package. Each
+	// has the fields Package, Added, Removed and Direct, the last three
+	// being lists of capability names, and the methods Note and Path
+	// which take a capability name and return its baseline note and
+	// example call path, and FromVersion and ToVersion which return the
+	// versions of the package's module if they changed, and Current
+	// which returns the package's complete current capability set when
+	// -diff-context is set.
+	Changes []change
+}
+
+// readTemplate parses the text/template file at path.
+func readTemplate(path string) (*template.Template, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(path)).Parse(string(b))
+}
+
+// writeTemplate writes changes to w using the template t.
+func writeTemplate(w io.Writer, t *template.Template, changes []change) error {
+	return t.Execute(w, templateData{Changes: changes})
+}
+
+// Note returns the baseline note for the changed capability.
+func (c change) Note(capability string) string {
+	return c.notes[capability]
+}
+
+// Path returns an example call path for the changed capability.
+func (c change) Path(capability string) []function {
+	return c.paths[capability]
+}
+
+// FromVersion returns the baseline version of the package's module if it
+// has changed.
+func (c change) FromVersion() string {
+	return c.from
+}
+
+// ToVersion returns the current version of the package's module if it has
+// changed.
+func (c change) ToVersion() string {
+	return c.to
+}
+
+// Current returns the complete current capability set of the package if
+// the context of changes is reported.
+func (c change) Current() []string {
+	return c.current
+}
@@ -0,0 +1,9 @@
+package main
+
+import (
+	"testing"
+
+	"example.com/app-dep/linuxtest"
+)
+
+func TestFoo(t *testing.T) { linuxtest.F() }
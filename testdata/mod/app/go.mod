@@ -0,0 +1,7 @@
+module example.com/app
+
+go 1.20
+
+require example.com/app-dep v0.0.0
+
+replace example.com/app-dep => ../dep
@@ -0,0 +1,5 @@
+package util
+
+import "example.com/app-dep/ignored"
+
+func F() { ignored.F() }
@@ -0,0 +1,13 @@
+package main
+
+import (
+	dep "example.com/app-dep"
+	"example.com/app-dep/sub"
+	"example.com/app/internal/util"
+)
+
+func main() {
+	dep.F()
+	sub.F()
+	util.F()
+}
@@ -0,0 +1,3 @@
+module example.com/app-dep
+
+go 1.20
@@ -0,0 +1,3 @@
+package linuxtest
+
+func F() {}
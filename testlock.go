@@ -0,0 +1,35 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// testLockPath returns the path of the lock holding the capabilities of
+// test-only imports that accompanies the lock at path.
+func testLockPath(lock string) string {
+	return strings.TrimSuffix(lock, filepath.Ext(lock)) + ".test.lock"
+}
+
+// isTestVariant returns whether pkg was loaded for testing, either as a
+// package compiled with its test files or as an external test package,
+// which the go tool identifies as "p [p.test]" and "p_test [p.test]".
+func isTestVariant(pkg *packages.Package) bool {
+	return strings.HasSuffix(pkg.ID, ".test]")
+}
+
+// splitTestImports returns the imports that are reached from
+// non-test packages, as recorded in prod, and the remaining test-only
+// imports.
+func splitTestImports(imports []string, prod map[string]bool) (nonTest, testOnly []string) {
+	for _, imp := range imports {
+		if prod[imp] {
+			nonTest = append(nonTest, imp)
+		} else {
+			testOnly = append(testOnly, imp)
+		}
+	}
+	return nonTest, testOnly
+}
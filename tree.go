@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// writeTree writes the analysed imports of the first-party packages in pkgs
+// to w as an indented tree, with each import followed by its capabilities
+// in r. Only imports in the analysed set, imports, are included. An import
+// that has already been expanded is marked "(shown above)" and an import of
+// a package on its own path is marked "(cycle)"; neither is expanded again.
+// The pkgs must have been loaded with packages.NeedName and
+// packages.NeedDeps.
+func writeTree(w io.Writer, pkgs []*packages.Package, imports []string, r *capslockReport) error {
+	analysed := make(map[string]bool, len(imports))
+	for _, imp := range imports {
+		analysed[imp] = true
+	}
+	caps := capabilities(r)
+	// Test variants are merged with the package they test.
+	roots := make(map[string][]*packages.Package)
+	for _, pkg := range pkgs {
+		if strings.HasSuffix(pkg.ID, ".test") {
+			// Skip synthesized test main packages.
+			continue
+		}
+		roots[pkg.PkgPath] = append(roots[pkg.PkgPath], pkg)
+	}
+	paths := make([]string, 0, len(roots))
+	for p := range roots {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	expanded := make(map[string]bool)
+	onPath := make(map[string]bool)
+	var err error
+	var walk func(variants []*packages.Package, depth int)
+	walk = func(variants []*packages.Package, depth int) {
+		children := make(map[string]*packages.Package)
+		for _, pkg := range variants {
+			for imp, dep := range pkg.Imports {
+				if analysed[imp] {
+					children[imp] = dep
+				}
+			}
+		}
+		names := make([]string, 0, len(children))
+		for imp := range children {
+			names = append(names, imp)
+		}
+		sort.Strings(names)
+		for _, imp := range names {
+			line := strings.Repeat("  ", depth) + imp
+			if c := capabilityNames(caps[imp]); len(c) != 0 {
+				line += " [" + strings.Join(c, ", ") + "]"
+			}
+			switch {
+			case onPath[imp]:
+				line += " (cycle)"
+			case expanded[imp]:
+				line += " (shown above)"
+			}
+			if err == nil {
+				_, err = fmt.Fprintln(w, line)
+			}
+			if onPath[imp] || expanded[imp] {
+				continue
+			}
+			expanded[imp] = true
+			onPath[imp] = true
+			walk([]*packages.Package{children[imp]}, depth+1)
+			onPath[imp] = false
+		}
+	}
+	for _, p := range paths {
+		if err == nil {
+			_, err = fmt.Fprintln(w, p)
+		}
+		onPath[p] = true
+		walk(roots[p], 1)
+		onPath[p] = false
+	}
+	return err
+}
+
+// capabilityNames returns the sorted names of the capabilities in caps.
+func capabilityNames(caps map[string]capabilityInfo) []string {
+	names := make([]string, 0, len(caps))
+	for c := range caps {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	return names
+}